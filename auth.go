@@ -2,64 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
+	"net/url"
+	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 )
 
-// トークンファイルのパスを取得
-func getTokenPath() (string, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("failed to get home directory: %v", err)
-	}
-	
-	configDir := filepath.Join(homeDir, ".gphoto-cli")
-	
-	// ディレクトリが存在しない場合は作成
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create config directory: %v", err)
-	}
-	
-	return filepath.Join(configDir, "token.json"), nil
-}
-
 func getGoogleConfig() (*oauth2.Config, error) {
-	// 設定ファイルから読み込み（優先）
-	config, err := loadConfig()
+	// 設定ファイル・環境変数・フラグを重ねた設定
+	config, _, err := loadLayeredConfig(nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load config: %v", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := resolveClientSecret(config); err != nil {
+		return nil, fmt.Errorf("failed to load client secret: %w", err)
 	}
 
 	// 設定が完了していない場合はエラー
 	if config.GoogleClientID == "" || config.GoogleClientSecret == "" {
-		return nil, fmt.Errorf("Google OAuth credentials not configured. Please run: ./gphoto-cli setup")
-	}
-
-	// 環境変数からの上書き（オプション）
-	if err := godotenv.Load(); err == nil {
-		if envClientID := os.Getenv("GOOGLE_CLIENT_ID"); envClientID != "" {
-			config.GoogleClientID = envClientID
-		}
-		if envClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET"); envClientSecret != "" {
-			config.GoogleClientSecret = envClientSecret
-		}
-		if envRedirectURI := os.Getenv("GOOGLE_REDIRECT_URI"); envRedirectURI != "" {
-			config.GoogleRedirectURI = envRedirectURI
-		}
-		if envScope := os.Getenv("GOOGLE_SCOPE"); envScope != "" {
-			config.GoogleScope = envScope
-		}
-		if envAuthMethod := os.Getenv("AUTH_METHOD"); envAuthMethod != "" {
-			config.AuthMethod = envAuthMethod
-		}
+		return nil, ErrNotConfigured
 	}
 
 	// OAuth2設定を構築
@@ -69,230 +40,413 @@ func getGoogleConfig() (*oauth2.Config, error) {
 		RedirectURL:  config.GoogleRedirectURI,
 		Scopes:       []string{config.GoogleScope},
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://accounts.google.com/o/oauth2/auth",
-			TokenURL: "https://oauth2.googleapis.com/token",
+			AuthURL:       config.AuthURL,
+			TokenURL:      config.TokenURL,
+			DeviceAuthURL: config.DeviceAuthURL,
 		},
 	}
 
 	return oauthConfig, nil
 }
 
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	// 設定ファイルから認証方式を取得
-	appConfig, err := loadConfig()
+// 認証の案内は w に書き出す
+func getTokenFromWeb(config *oauth2.Config, w io.Writer) (*oauth2.Token, error) {
+	// 設定ファイル・環境変数から認証方式を取得
+	appConfig, _, err := loadLayeredConfig(nil)
 	if err != nil {
-		fmt.Printf("Warning: failed to load config: %v\n", err)
-		fmt.Println("自動認証方式を使用します")
-		return getTokenWithLocalServer(config)
+		fmt.Fprintf(w, "Warning: failed to load config: %v\n", err)
+		fmt.Fprintln(w, "自動認証方式を使用します")
+		return getTokenWithLocalServer(config, w)
 	}
 
-	// 環境変数での上書きもチェック
-	authMethod := appConfig.AuthMethod
-	if envAuthMethod := os.Getenv("AUTH_METHOD"); envAuthMethod != "" {
-		authMethod = envAuthMethod
-	}
+	return getTokenWithMethod(config, appConfig.AuthMethod, w)
+}
 
+// 指定した認証方式でブラウザ認証を行う
+func getTokenWithMethod(config *oauth2.Config, authMethod string, w io.Writer) (*oauth2.Token, error) {
 	switch authMethod {
 	case "server":
-		fmt.Println("自動認証方式を使用します (ローカルサーバー)")
-		return getTokenWithLocalServer(config)
+		fmt.Fprintln(w, "自動認証方式を使用します (ローカルサーバー)")
+		return getTokenWithLocalServer(config, w)
+	case "device":
+		fmt.Fprintln(w, "デバイス認証方式を使用します")
+		return getTokenWithDeviceFlow(config, w)
 	case "oob":
-		fmt.Println("手動認証方式を使用します (認証コード入力)")
-		return getTokenManually(config)
+		// Google は OOB フローを廃止したため、デバイス認証で代替する
+		fmt.Fprintln(w, "⚠️  auth_method: oob は Google により廃止されました。デバイス認証方式を使用します")
+		fmt.Fprintln(w, "   ./gphoto-cli setup を再実行するか、config.yaml の auth_method を device に変更してください")
+		return getTokenWithDeviceFlow(config, w)
 	default:
-		fmt.Printf("不明な認証方式: %s\n", authMethod)
-		fmt.Println("自動認証方式を使用します")
-		return getTokenWithLocalServer(config)
+		fmt.Fprintf(w, "不明な認証方式: %s\n", authMethod)
+		fmt.Fprintln(w, "自動認証方式を使用します")
+		return getTokenWithLocalServer(config, w)
 	}
 }
 
-func getTokenWithLocalServer(config *oauth2.Config) *oauth2.Token {
-	codeCh := make(chan string)
-	state := "state-token"
-	
-	// 設定からポート番号を決定
-	port := ":8080" // デフォルト
-	if config.RedirectURL != "" && config.RedirectURL != "urn:ietf:wg:oauth:2.0:oob" {
-		// 既にconfigに設定されているRedirectURLを使用
+// コールバックの state が認可リクエストと一致しない場合のエラー
+var errStateMismatch = errors.New("OAuth state mismatch: the callback did not come from this login attempt")
+
+// ユーザーが認可を拒否した場合などにコールバックが error パラメータを返した場合のエラー
+var errAuthorizationDenied = errors.New("authorization was denied")
+
+// コールバックに認証コードが含まれていない場合のエラー
+var errMissingAuthCode = errors.New("authorization callback did not include a code")
+
+// 認可リクエストごとに生成する state と PKCE の code verifier
+type authFlowParams struct {
+	State    string
+	Verifier string
+}
+
+// 暗号学的に安全な乱数から state と PKCE verifier を生成
+func newAuthFlowParams() (*authFlowParams, error) {
+	stateBytes := make([]byte, 32)
+	if _, err := rand.Read(stateBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate state: %w", err)
 	}
-	
-	// ローカルサーバーを起動
-	server := &http.Server{Addr: port}
-	
-	http.HandleFunc("/auth/callback", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Query().Get("state") != state {
+
+	return &authFlowParams{
+		State:    base64.RawURLEncoding.EncodeToString(stateBytes),
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// S256 の code_challenge 付きの認証URLを生成
+func (p *authFlowParams) authCodeURL(config *oauth2.Config) string {
+	return config.AuthCodeURL(p.State, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(p.Verifier))
+}
+
+// 認証コードを code_verifier 付きでトークンと交換
+func (p *authFlowParams) exchange(ctx context.Context, config *oauth2.Config, code string) (*oauth2.Token, error) {
+	return config.Exchange(ctx, code, oauth2.VerifierOption(p.Verifier))
+}
+
+// 認証コールバックのハンドラ
+// 認証コードは codeCh に、state の不一致や認可拒否、コードがない場合は errCh に送る（どちらもバッファ付きを想定）
+// ログインがタイムアウトまで待ち続けないよう、失敗した場合もすぐに結果を送る
+func newAuthCallbackHandler(state string, codeCh chan<- string, errCh chan<- error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if authErr := query.Get("error"); authErr != "" {
+			http.Error(w, "Authorization failed: "+authErr, http.StatusBadRequest)
+			sendNonBlocking(errCh, fmt.Errorf("%w: %s", errAuthorizationDenied, authErr))
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
 			http.Error(w, "State mismatch", http.StatusBadRequest)
+			sendNonBlocking(errCh, errStateMismatch)
 			return
 		}
 		
-		code := r.URL.Query().Get("code")
+		code := query.Get("code")
 		if code == "" {
 			http.Error(w, "No code in request", http.StatusBadRequest)
+			sendNonBlocking(errCh, errMissingAuthCode)
 			return
 		}
 		
 		fmt.Fprintf(w, "<html><body><h1>認証が完了しました！</h1><p>このタブを閉じて、ターミナルに戻ってください。</p></body></html>")
 		
 		// コードをチャネルに送信
-		go func() {
-			codeCh <- code
-		}()
-	})
-	
+		sendNonBlocking(codeCh, code)
+	}
+}
+
+// 受信側が既に結果を受け取っている場合はブロックせずに捨てる
+func sendNonBlocking[T any](ch chan<- T, v T) {
+	select {
+	case ch <- v:
+	default:
+	}
+}
+
+// 認証コールバックを受け取るループバックサーバー
+type callbackServer struct {
+	// 実際にリッスンしているポートを反映したリダイレクトURI
+	RedirectURL string
+	Codes       <-chan string
+	Errors      <-chan error
+
+	server *http.Server
+}
+
+// ループバックアドレス（127.0.0.1）でコールバックサーバーを起動する
+// redirectURL のポートが使用中または未指定（0）の場合は空いているポートを使う
+// ハンドラは専用の ServeMux に登録するので、同じプロセスで何度でも起動できる
+func startCallbackServer(redirectURL, state string, w io.Writer) (*callbackServer, error) {
+	port, path := loopbackPortAndPath(redirectURL)
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil && port != "0" {
+		fmt.Fprintf(w, "ポート %s が使用できないため、空いているポートを使用します (%v)\n", port, err)
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on loopback address: %w", err)
+	}
+
+	codeCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, newAuthCallbackHandler(state, codeCh, errCh))
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
 	// サーバーを別ゴルーチンで起動
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("ローカルサーバーエラー: %v", err)
-			log.Println("手動認証方式に切り替えてください")
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			sendNonBlocking(errCh, fmt.Errorf("local callback server failed: %w", err))
 		}
 	}()
+
+	actualPort := listener.Addr().(*net.TCPAddr).Port
+	return &callbackServer{
+		RedirectURL: fmt.Sprintf("http://127.0.0.1:%d%s", actualPort, path),
+		Codes:       codeCh,
+		Errors:      errCh,
+		server:      server,
+	}, nil
+}
+
+// サーバーを停止（処理中のリクエストの完了を待つ）
+func (cs *callbackServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cs.server.Shutdown(ctx); err != nil {
+		cs.server.Close()
+	}
+}
+
+// リダイレクトURIからリッスンするポートとコールバックのパスを決定
+// ループバックの http URI でない場合は空きポートとデフォルトのパスを使う
+func loopbackPortAndPath(redirectURL string) (port, path string) {
+	port, path = "0", "/auth/callback"
+
+	u, err := url.Parse(redirectURL)
+	if err != nil || u.Scheme != "http" {
+		return port, path
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+	default:
+		return port, path
+	}
+
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	if u.Path != "" && u.Path != "/" {
+		path = u.Path
+	}
+	return port, path
+}
+
+func getTokenWithLocalServer(config *oauth2.Config, w io.Writer) (*oauth2.Token, error) {
+	params, err := newAuthFlowParams()
+	if err != nil {
+		return nil, fmt.Errorf("認証の準備に失敗しました: %w", err)
+	}
+
+	// ローカルサーバーを起動
+	callback, err := startCallbackServer(config.RedirectURL, params.State, w)
+	if err != nil {
+		fmt.Fprintf(w, "ローカルサーバーを起動できませんでした: %v\n", err)
+		// デバイス認証にフォールバック
+		return getTokenWithDeviceFlow(config, w)
+	}
+
+	// 実際のポートに合わせたリダイレクトURIを使う（呼び出し元の設定は変更しない）
+	localConfig := *config
+	localConfig.RedirectURL = callback.RedirectURL
 	
 	// 認証URLを生成
-	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	authURL := params.authCodeURL(&localConfig)
 	
-	fmt.Printf("ブラウザで以下のURLを開いて認証を行ってください:\n%v\n\n", authURL)
-	fmt.Println("認証完了まで待機中...")
+	fmt.Fprintf(w, "ブラウザで以下のURLを開いて認証を行ってください:\n%v\n\n", authURL)
+	fmt.Fprintln(w, "認証完了まで待機中...")
 	
 	// 認証コードを待機（タイムアウト付き）
 	var code string
 	select {
-	case code = <-codeCh:
-		fmt.Println("認証コードを受信しました")
+	case code = <-callback.Codes:
+		fmt.Fprintln(w, "認証コードを受信しました")
+	case err := <-callback.Errors:
+		callback.Close()
+		return nil, fmt.Errorf("認証に失敗しました: %w", err)
 	case <-time.After(3 * time.Minute):
-		fmt.Println("ローカルサーバー認証がタイムアウトしました")
-		// サーバーを停止
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-		server.Shutdown(ctx)
-		// 手動認証にフォールバック
-		return getTokenManually(config)
+		fmt.Fprintln(w, "ローカルサーバー認証がタイムアウトしました")
+		callback.Close()
+		// デバイス認証にフォールバック
+		return getTokenWithDeviceFlow(config, w)
 	}
 	
-	// サーバーを停止
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	server.Shutdown(ctx)
+	callback.Close()
 	
-	// トークンを取得
-	tok, err := config.Exchange(context.TODO(), code)
+	// トークンを取得（認可リクエストと同じリダイレクトURIが必要）
+	tok, err := params.exchange(context.TODO(), &localConfig, code)
 	if err != nil {
-		log.Fatalf("トークンの取得に失敗しました: %v", err)
+		return nil, fmt.Errorf("トークンの取得に失敗しました: %w", err)
 	}
 	
-	return tok
+	return tok, nil
 }
 
-func getTokenManually(config *oauth2.Config) *oauth2.Token {
-	// デスクトップアプリケーション用のOOB (Out of Band) フロー
-	config.RedirectURL = "urn:ietf:wg:oauth:2.0:oob"
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	
-	fmt.Printf("\n=== 手動認証方式 ===\n")
-	fmt.Printf("1. ブラウザで以下のURLを開いてください:\n%v\n\n", authURL)
-	fmt.Println("2. Google認証を完了してください")
-	fmt.Println("3. 表示された認証コードをコピーしてください")
-	
-	var authCode string
-	fmt.Print("\n認証コードを入力してください: ")
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("認証コードの読み取りに失敗しました: %v", err)
-	}
-	
-	tok, err := config.Exchange(context.TODO(), authCode)
+func getTokenWithDeviceFlow(config *oauth2.Config, w io.Writer) (*oauth2.Token, error) {
+	tok, err := deviceFlowToken(context.TODO(), config, w)
 	if err != nil {
-		log.Fatalf("トークンの取得に失敗しました: %v", err)
+		return nil, fmt.Errorf("デバイス認証に失敗しました: %w", err)
 	}
-	
-	return tok
+	return tok, nil
 }
 
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
+// デバイス認可グラント (RFC 8628)
+// 認証URLとユーザーコードを表示し、別の端末での承認が終わるまでトークンエンドポイントをポーリングする
+func deviceFlowToken(ctx context.Context, config *oauth2.Config, w io.Writer) (*oauth2.Token, error) {
+	da, err := config.DeviceAuth(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
 	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
 
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	fmt.Fprintf(w, "\n=== デバイス認証 ===\n")
+	fmt.Fprintf(w, "1. 任意の端末のブラウザで以下のURLを開いてください:\n%s\n\n", da.VerificationURI)
+	fmt.Fprintf(w, "2. 次のコードを入力してください: %s\n\n", da.UserCode)
+	if da.VerificationURIComplete != "" {
+		fmt.Fprintf(w, "（コード入力済みのURL: %s）\n\n", da.VerificationURIComplete)
+	}
+	if !da.Expiry.IsZero() {
+		fmt.Fprintf(w, "コードの有効期限: %s\n", da.Expiry.Local().Format("15:04:05"))
+	}
+	fmt.Fprintln(w, "承認されるまで待機中...")
+
+	// authorization_pending / slow_down の間は interval に従ってポーリングが続く
+	tok, err := config.DeviceAccessToken(ctx, da)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return nil, fmt.Errorf("failed to get token: %w", err)
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+
+	return tok, nil
 }
 
-func getClient(config *oauth2.Config) *http.Client {
-	tokenPath, err := getTokenPath()
+// 認証情報ストアから保存済みのトークンを読み込む
+func loadToken() (*oauth2.Token, error) {
+	store, err := activeCredentialStore()
 	if err != nil {
-		log.Fatalf("Failed to get token path: %v", err)
+		return nil, err
 	}
-	
-	tok, err := tokenFromFile(tokenPath)
+	data, err := store.Get(credentialKeyToken)
 	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokenPath, tok)
+		return nil, err
 	}
-	
-	// トークンの有効期限をチェック
-	if !tok.Valid() {
-		fmt.Println("アクセストークンの有効期限が切れています。リフレッシュしています...")
-		
-		// OAuth2のTokenSourceを使用してトークンを自動リフレッシュ
-		tokenSource := config.TokenSource(context.Background(), tok)
-		newTok, err := tokenSource.Token()
-		if err != nil {
-			fmt.Println("トークンのリフレッシュに失敗しました。再認証が必要です。")
-			// リフレッシュに失敗した場合は再認証
-			tok = getTokenFromWeb(config)
-		} else {
-			tok = newTok
-			fmt.Println("アクセストークンが正常にリフレッシュされました。")
-		}
-		
-		// 新しいトークンを保存
-		saveToken(tokenPath, tok)
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(data, tok); err != nil {
+		return nil, fmt.Errorf("failed to parse stored token: %w", err)
 	}
-	
-	return config.Client(context.Background(), tok)
+	return tok, nil
 }
 
-func getAccessToken(config *oauth2.Config) (string, error) {
-	tokenPath, err := getTokenPath()
+func saveToken(token *oauth2.Token, w io.Writer) error {
+	store, err := activeCredentialStore()
 	if err != nil {
-		return "", fmt.Errorf("failed to get token path: %v", err)
+		return fmt.Errorf("unable to open credential store: %w", err)
 	}
-	
-	tok, err := tokenFromFile(tokenPath)
+	fmt.Fprintf(w, "Saving credential to: %s\n", store.Name())
+	data, err := json.Marshal(token)
 	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokenPath, tok)
+		return fmt.Errorf("unable to encode oauth token: %w", err)
 	}
-	
-	// トークンの有効期限をチェック
+	if err := store.Set(credentialKeyToken, data); err != nil {
+		return fmt.Errorf("unable to cache oauth token: %w", err)
+	}
+	return nil
+}
+
+// リフレッシュされたトークンを認証情報ストアに書き戻す TokenSource
+type persistingTokenSource struct {
+	source oauth2.TokenSource
+	// 保存先や保存に失敗した場合の警告の表示先
+	out io.Writer
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.source.Token()
+	if err != nil {
+		return nil, wrapTokenError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil || tok.AccessToken != s.last.AccessToken {
+		// 保存に失敗してもこの実行中はリフレッシュしたトークンを使える
+		if err := saveToken(tok, s.out); err != nil {
+			fmt.Fprintf(s.out, "Warning: %v\n", err)
+		}
+		s.last = tok
+	}
+	return tok, nil
+}
+
+// 保存済みのトークン（なければログイン）から、期限切れ時に自動でリフレッシュして保存する TokenSource を作成
+// ログインやリフレッシュの進捗は w に書き出す
+func newTokenSource(ctx context.Context, config *oauth2.Config, w io.Writer) (oauth2.TokenSource, error) {
+	tok, err := loadToken()
+	if errors.Is(err, errCredentialNotFound) {
+		if tok, err = getTokenFromWeb(config, w); err != nil {
+			return nil, err
+		}
+		if err := saveToken(tok, w); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load token: %w", err)
+	}
+
+	// 起動時点で期限切れならここでリフレッシュし、トークンが失効している場合は再認証する
 	if !tok.Valid() {
-		fmt.Println("アクセストークンの有効期限が切れています。リフレッシュしています...")
-		
-		// OAuth2のTokenSourceを使用してトークンを自動リフレッシュ
-		tokenSource := config.TokenSource(context.Background(), tok)
-		newTok, err := tokenSource.Token()
-		if err != nil {
-			fmt.Println("トークンのリフレッシュに失敗しました。再認証が必要です。")
-			// リフレッシュに失敗した場合は再認証
-			tok = getTokenFromWeb(config)
-		} else {
+		fmt.Fprintln(w, "アクセストークンの有効期限が切れています。リフレッシュしています...")
+
+		newTok, err := config.TokenSource(ctx, tok).Token()
+		switch err := wrapTokenError(err); {
+		case errors.Is(err, ErrTokenRevoked):
+			fmt.Fprintln(w, "トークンのリフレッシュに失敗しました。再認証が必要です。")
+			if tok, err = getTokenFromWeb(config, w); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, fmt.Errorf("failed to refresh token: %w", err)
+		default:
 			tok = newTok
-			fmt.Println("アクセストークンが正常にリフレッシュされました。")
+			fmt.Fprintln(w, "アクセストークンが正常にリフレッシュされました。")
 		}
-		
+
 		// 新しいトークンを保存
-		saveToken(tokenPath, tok)
+		if err := saveToken(tok, w); err != nil {
+			return nil, err
+		}
 	}
-	
-	return tok.AccessToken, nil
-}
\ No newline at end of file
+
+	// 実行中に期限が切れた場合も透過的にリフレッシュされる
+	return oauth2.ReuseTokenSource(tok, &persistingTokenSource{
+		source: config.TokenSource(ctx, tok),
+		out:    w,
+		last:   tok,
+	}), nil
+}
+
+// すべてのリクエストに Authorization ヘッダーを付ける HTTP クライアント
+// 認証の案内やトークンの保存先は w に書き出す（構造化出力の場合は標準エラーを渡す）
+func getClient(ctx context.Context, config *oauth2.Config, w io.Writer) (*http.Client, error) {
+	tokenSource, err := newTokenSource(ctx, config, w)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, tokenSource), nil
+}
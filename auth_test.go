@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func fakeOAuthConfig(fake *fakePickerServer) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		RedirectURL:  "http://127.0.0.1:8080/auth/callback",
+		Scopes:       []string{"https://www.googleapis.com/auth/photospicker.mediaitems.readonly"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       fake.URL + "/auth",
+			TokenURL:      fake.URL + "/token",
+			DeviceAuthURL: fake.URL + "/device/code",
+		},
+	}
+}
+
+func TestNewAuthFlowParamsAreRandom(t *testing.T) {
+	first, err := newAuthFlowParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newAuthFlowParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.State == second.State || first.Verifier == second.Verifier {
+		t.Error("state and verifier must differ between login attempts")
+	}
+	if len(first.State) < 32 {
+		t.Errorf("state %q is too short", first.State)
+	}
+}
+
+func TestAuthCodeURLIncludesPKCEChallenge(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	params, err := newAuthFlowParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := url.Parse(params.authCodeURL(fakeOAuthConfig(fake)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+
+	if query.Get("state") != params.State {
+		t.Errorf("state = %q, want %q", query.Get("state"), params.State)
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+	if query.Get("code_challenge") != oauth2.S256ChallengeFromVerifier(params.Verifier) {
+		t.Error("code_challenge does not match the verifier")
+	}
+	if query.Get("access_type") != "offline" {
+		t.Errorf("access_type = %q, want offline", query.Get("access_type"))
+	}
+}
+
+func TestAuthCallbackHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCode   string
+		wantErr    error
+	}{
+		{name: "valid callback", query: "state=expected&code=abc", wantStatus: http.StatusOK, wantCode: "abc"},
+		{name: "state mismatch", query: "state=forged&code=abc", wantStatus: http.StatusBadRequest, wantErr: errStateMismatch},
+		{name: "missing state", query: "code=abc", wantStatus: http.StatusBadRequest, wantErr: errStateMismatch},
+		{name: "access denied", query: "state=expected&error=access_denied", wantStatus: http.StatusBadRequest, wantErr: errAuthorizationDenied},
+		{name: "missing code", query: "state=expected", wantStatus: http.StatusBadRequest, wantErr: errMissingAuthCode},
+		{name: "empty code", query: "state=expected&code=", wantStatus: http.StatusBadRequest, wantErr: errMissingAuthCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codeCh := make(chan string, 1)
+			errCh := make(chan error, 1)
+			handler := newAuthCallbackHandler("expected", codeCh, errCh)
+
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest("GET", "/auth/callback?"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			select {
+			case code := <-codeCh:
+				if code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+			default:
+				if tt.wantCode != "" {
+					t.Error("expected a code to be delivered")
+				}
+			}
+
+			select {
+			case err := <-errCh:
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if tt.wantErr != nil {
+					t.Error("expected an error to be delivered")
+				}
+			}
+		})
+	}
+}
+
+func TestPKCEExchangeAgainstFakeTokenEndpoint(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	config := fakeOAuthConfig(fake)
+
+	params, err := newAuthFlowParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, _ := url.Parse(params.authCodeURL(config))
+	code := fake.issueAuthCode(authURL.Query().Get("code_challenge"))
+
+	// 別のログイン試行の verifier では交換できない
+	other, _ := newAuthFlowParams()
+	if _, err := other.exchange(context.Background(), config, code); err == nil {
+		t.Fatal("exchange with a different verifier should fail")
+	}
+
+	tok, err := params.exchange(context.Background(), config, code)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if tok.AccessToken != fake.refreshedToken || tok.RefreshToken == "" {
+		t.Errorf("unexpected token: %+v", tok)
+	}
+}
+
+func TestLoopbackPortAndPath(t *testing.T) {
+	tests := []struct {
+		redirectURL string
+		wantPort    string
+		wantPath    string
+	}{
+		{"http://localhost:8080/auth/callback", "8080", "/auth/callback"},
+		{"http://127.0.0.1:9123/cb", "9123", "/cb"},
+		{"http://localhost/auth/callback", "0", "/auth/callback"},
+		{"urn:ietf:wg:oauth:2.0:oob", "0", "/auth/callback"},
+		{"https://example.com:8443/auth/callback", "0", "/auth/callback"},
+		{"", "0", "/auth/callback"},
+	}
+
+	for _, tt := range tests {
+		port, path := loopbackPortAndPath(tt.redirectURL)
+		if port != tt.wantPort || path != tt.wantPath {
+			t.Errorf("loopbackPortAndPath(%q) = %q, %q; want %q, %q", tt.redirectURL, port, path, tt.wantPort, tt.wantPath)
+		}
+	}
+}
+
+func TestCallbackServerDeliversCode(t *testing.T) {
+	// 同じプロセスで複数回ログインしてもハンドラの重複登録で panic しない
+	for i := 0; i < 2; i++ {
+		callback, err := startCallbackServer("http://localhost:0/auth/callback", "expected", io.Discard)
+		if err != nil {
+			t.Fatalf("startCallbackServer: %v", err)
+		}
+
+		u, err := url.Parse(callback.RedirectURL)
+		if err != nil || u.Hostname() != "127.0.0.1" || u.Port() == "0" || u.Path != "/auth/callback" {
+			t.Fatalf("unexpected redirect URL %q", callback.RedirectURL)
+		}
+
+		resp, err := http.Get(callback.RedirectURL + "?state=expected&code=code-123")
+		if err != nil {
+			t.Fatalf("callback request: %v", err)
+		}
+		resp.Body.Close()
+
+		if code := <-callback.Codes; code != "code-123" {
+			t.Errorf("code = %q, want code-123", code)
+		}
+
+		callback.Close()
+		if _, err := http.Get(callback.RedirectURL); err == nil {
+			t.Error("server should not accept connections after Close")
+		}
+	}
+}
+
+func TestCallbackServerReportsMissingCode(t *testing.T) {
+	callback, err := startCallbackServer("http://127.0.0.1:0/auth/callback", "expected", io.Discard)
+	if err != nil {
+		t.Fatalf("startCallbackServer: %v", err)
+	}
+	defer callback.Close()
+
+	resp, err := http.Get(callback.RedirectURL + "?state=expected")
+	if err != nil {
+		t.Fatalf("callback request: %v", err)
+	}
+	resp.Body.Close()
+
+	// タイムアウトを待たずにエラーが届く
+	select {
+	case err := <-callback.Errors:
+		if !errors.Is(err, errMissingAuthCode) {
+			t.Errorf("err = %v, want %v", err, errMissingAuthCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a callback without a code should be reported right away")
+	}
+}
+
+func TestCallbackServerFallsBackWhenPortIsBusy(t *testing.T) {
+	busy, err := startCallbackServer("http://127.0.0.1:0/auth/callback", "state", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	callback, err := startCallbackServer(busy.RedirectURL, "state", io.Discard)
+	if err != nil {
+		t.Fatalf("startCallbackServer: %v", err)
+	}
+	defer callback.Close()
+
+	if callback.RedirectURL == busy.RedirectURL {
+		t.Errorf("expected a different port, both use %q", callback.RedirectURL)
+	}
+}
+
+func TestDeviceFlowToken(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	fake.devicePendingPolls = 1
+
+	var out bytes.Buffer
+	tok, err := deviceFlowToken(context.Background(), fakeOAuthConfig(fake), &out)
+	if err != nil {
+		t.Fatalf("deviceFlowToken: %v", err)
+	}
+	if tok.AccessToken != fake.refreshedToken {
+		t.Errorf("access token = %q, want %q", tok.AccessToken, fake.refreshedToken)
+	}
+	if !strings.Contains(out.String(), fake.URL+"/device") || !strings.Contains(out.String(), "ABCD-EFGH") {
+		t.Errorf("output should show the verification URL and user code, got:\n%s", out.String())
+	}
+	if len(fake.deviceCodes) != 0 {
+		t.Errorf("device code should be consumed, remaining %v", fake.deviceCodes)
+	}
+}
+
+func TestFetchTokenInfo(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+
+	info, err := fetchTokenInfo(context.Background(), fake.Client(), fake.URL+"/tokeninfo", fake.accessToken)
+	if err != nil {
+		t.Fatalf("fetchTokenInfo: %v", err)
+	}
+	if info.Email != "" || info.Scope != fake.tokenScope {
+		t.Errorf("without the email scope: got %+v", info)
+	}
+
+	// auth login --with-email で email スコープを許可した場合のみメールアドレスが返る
+	fake.tokenScope += " " + accountEmailScope
+	info, err = fetchTokenInfo(context.Background(), fake.Client(), fake.URL+"/tokeninfo", fake.accessToken)
+	if err != nil {
+		t.Fatalf("fetchTokenInfo: %v", err)
+	}
+	if info.Email != "user@example.com" || !strings.Contains(info.Scope, accountEmailScope) {
+		t.Errorf("with the email scope: got %+v", info)
+	}
+
+	if _, err := fetchTokenInfo(context.Background(), fake.Client(), fake.URL+"/tokeninfo", "wrong-token"); err == nil {
+		t.Error("fetchTokenInfo with an invalid token should fail")
+	}
+}
+
+func TestAuthRevokeRemovesToken(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+	refreshToken := fake.refreshToken
+
+	if err := runAuthRevoke(); err != nil {
+		t.Fatalf("runAuthRevoke: %v", err)
+	}
+	if len(fake.revokedTokens) != 1 || fake.revokedTokens[0] != refreshToken {
+		t.Errorf("revoked %v, want the refresh token", fake.revokedTokens)
+	}
+	if _, err := loadToken(); !errors.Is(err, errCredentialNotFound) {
+		t.Errorf("token should be removed after revoke, err = %v", err)
+	}
+
+	// ログインしていない状態での revoke / status はエラーにならない
+	if err := runAuthRevoke(); err != nil {
+		t.Errorf("second runAuthRevoke: %v", err)
+	}
+	if err := runAuthStatus(); err != nil {
+		t.Errorf("runAuthStatus while logged out: %v", err)
+	}
+}
+
+func TestRevokeTokenTreatsInvalidTokenAsRevoked(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+
+	if err := revokeToken(context.Background(), fake.Client(), fake.URL+"/revoke", "already-revoked"); err != nil {
+		t.Errorf("revokeToken: %v", err)
+	}
+}
+
+func TestPersistingTokenSourceWarnsOnInjectedWriter(t *testing.T) {
+	setTestHome(t)
+	// 保存先を開けないようにする
+	t.Setenv("GPHOTO_CREDENTIAL_STORE", "unknown-store")
+
+	var out bytes.Buffer
+	source := &persistingTokenSource{
+		source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "refreshed"}),
+		out:    &out,
+	}
+	tok, err := source.Token()
+	if err != nil {
+		t.Fatalf("Token should succeed even if saving fails: %v", err)
+	}
+	if tok.AccessToken != "refreshed" {
+		t.Errorf("access token = %q, want refreshed", tok.AccessToken)
+	}
+	if !strings.Contains(out.String(), "Warning:") {
+		t.Errorf("save failure should be reported on the injected writer, got %q", out.String())
+	}
+}
+
+func TestAuthStatus(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+
+	if err := runAuthStatus(); err != nil {
+		t.Fatalf("runAuthStatus: %v", err)
+	}
+
+	// 失効したリフレッシュトークンは ErrTokenRevoked になる
+	fake.refreshToken = "rotated"
+	setupFakeEnv(t, fake, &oauth2.Token{AccessToken: "expired", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)})
+	if err := runAuthStatus(); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("runAuthStatus with a revoked token = %v, want ErrTokenRevoked", err)
+	}
+}
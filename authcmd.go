@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+)
+
+// auth status でアカウントのメールアドレスを表示するためのスコープ（auth login --with-email で要求）
+const accountEmailScope = "https://www.googleapis.com/auth/userinfo.email"
+
+// tokeninfo エンドポイントのレスポンス
+type tokenInfo struct {
+	Email string `json:"email"`
+	Scope string `json:"scope"`
+}
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage Google account authentication",
+	Long:  "Log in, log out, revoke or inspect the OAuth token without touching the client ID/secret",
+}
+
+var authLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to Google and store a new OAuth token",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !isConfigured() {
+			return ErrNotConfigured
+		}
+
+		method, _ := cmd.Flags().GetString("method")
+		if method != "" && method != "server" && method != "device" {
+			return fmt.Errorf("invalid --method %q (want server or device)", method)
+		}
+		withEmail, _ := cmd.Flags().GetBool("with-email")
+		if err := runAuthLogin(method, withEmail); err != nil {
+			return fmt.Errorf("Error logging in: %w", err)
+		}
+		return nil
+	},
+}
+
+var authLogoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Remove the stored OAuth token (the grant stays valid at Google)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runAuthLogout(); err != nil {
+			return fmt.Errorf("Error logging out: %w", err)
+		}
+		return nil
+	},
+}
+
+var authRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke the OAuth grant at Google and remove the stored token",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runAuthRevoke(); err != nil {
+			return fmt.Errorf("Error revoking token: %w", err)
+		}
+		return nil
+	},
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the logged-in account, granted scopes and token expiry",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !isConfigured() {
+			return ErrNotConfigured
+		}
+
+		if err := runAuthStatus(); err != nil {
+			return fmt.Errorf("Error showing auth status: %w", err)
+		}
+		return nil
+	},
+}
+
+func runAuthLogin(method string, withEmail bool) error {
+	config, err := getGoogleConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get Google config: %w", err)
+	}
+	if withEmail {
+		config.Scopes = append(config.Scopes, accountEmailScope)
+	}
+
+	var tok *oauth2.Token
+	if method == "" {
+		tok, err = getTokenFromWeb(config, os.Stdout)
+	} else {
+		tok, err = getTokenWithMethod(config, method, os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if err := saveToken(tok, os.Stdout); err != nil {
+		return err
+	}
+
+	fmt.Println("✅ ログインしました")
+	return nil
+}
+
+func runAuthLogout() error {
+	store, err := activeCredentialStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(credentialKeyToken); err != nil {
+		return fmt.Errorf("failed to remove token from %s: %w", store.Name(), err)
+	}
+
+	fmt.Println("✅ ログアウトしました（Google 側の許可も取り消すには: ./gphoto-cli auth revoke）")
+	return nil
+}
+
+func runAuthRevoke() error {
+	tok, err := loadToken()
+	if errors.Is(err, errCredentialNotFound) {
+		fmt.Println("ログインしていません")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// リフレッシュトークンを取り消すと、そこから発行されたアクセストークンも無効になる
+	token := tok.RefreshToken
+	if token == "" {
+		token = tok.AccessToken
+	}
+	if err := revokeToken(context.Background(), http.DefaultClient, getEndpointConfig().RevokeURL, token); err != nil {
+		return err
+	}
+
+	if err := runAuthLogout(); err != nil {
+		return err
+	}
+	fmt.Println("✅ Google 側でトークンを取り消しました")
+	return nil
+}
+
+// トークンを取り消す（既に無効なトークンの場合も成功として扱う）
+func revokeToken(ctx context.Context, client *http.Client, revokeURL, token string) error {
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(ctx, "POST", revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	apiErr := newAPIError(resp)
+	var oauthErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(apiErr.Body), &oauthErr) == nil && oauthErr.Error == "invalid_token" {
+		fmt.Println("トークンは既に無効です")
+		return nil
+	}
+	return apiErr
+}
+
+func runAuthStatus() error {
+	store, err := activeCredentialStore()
+	if err != nil {
+		return err
+	}
+
+	tok, err := loadToken()
+	if errors.Is(err, errCredentialNotFound) {
+		fmt.Println("ログインしていません（ログインするには: ./gphoto-cli auth login）")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	config, err := getGoogleConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get Google config: %w", err)
+	}
+
+	fmt.Printf("認証情報の保存先: %s\n", store.Name())
+	if tok.RefreshToken != "" {
+		fmt.Println("リフレッシュトークン: あり")
+	} else {
+		fmt.Println("リフレッシュトークン: なし（期限が切れると再ログインが必要です）")
+	}
+
+	// 期限切れの場合はリフレッシュしてから問い合わせる（再ログインは行わない）
+	ctx := context.Background()
+	source := &persistingTokenSource{source: config.TokenSource(ctx, tok), out: os.Stdout, last: tok}
+	current, err := source.Token()
+	if err != nil {
+		if errors.Is(err, ErrTokenRevoked) {
+			fmt.Println("状態: ❌ トークンが失効しています（再ログインするには: ./gphoto-cli auth login）")
+			return err
+		}
+		return fmt.Errorf("failed to refresh token: %w", err)
+	}
+	fmt.Printf("有効期限: %s\n", formatTokenExpiry(current.Expiry))
+
+	info, err := fetchTokenInfo(ctx, http.DefaultClient, getEndpointConfig().TokenInfoURL, current.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to get token info: %w", err)
+	}
+
+	// メールアドレスは auth login --with-email で許可した場合のみ返される
+	email := info.Email
+	if email == "" {
+		email = "不明（表示するには: ./gphoto-cli auth login --with-email）"
+	}
+	fmt.Printf("アカウント: %s\n", email)
+	fmt.Println("許可されたスコープ:")
+	for _, scope := range strings.Fields(info.Scope) {
+		fmt.Printf("   - %s\n", scope)
+	}
+	fmt.Println("状態: ✅ 有効")
+
+	return nil
+}
+
+// アクセストークンの情報（アカウントとスコープ）を取得
+// トークンがログや履歴に残らないよう URL ではなくフォームで送る
+func fetchTokenInfo(ctx context.Context, client *http.Client, tokenInfoURL, accessToken string) (*tokenInfo, error) {
+	form := url.Values{"access_token": {accessToken}}
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenInfoURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var info tokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &info, nil
+}
+
+func formatTokenExpiry(expiry time.Time) string {
+	if expiry.IsZero() {
+		return "なし"
+	}
+	return fmt.Sprintf("%s (残り %d分)", expiry.Local().Format("2006-01-02 15:04:05"), int(time.Until(expiry).Minutes()))
+}
+
+func init() {
+	authLoginCmd.Flags().String("method", "", "Authentication method: server or device (default: auth_method in config.yaml)")
+	authLoginCmd.Flags().Bool("with-email", false, "Also request the userinfo.email scope so auth status can show the account email")
+
+	authCmd.AddCommand(authLoginCmd)
+	authCmd.AddCommand(authLogoutCmd)
+	authCmd.AddCommand(authRevokeCmd)
+	authCmd.AddCommand(authStatusCmd)
+
+	rootCmd.AddCommand(authCmd)
+}
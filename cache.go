@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// メディアキャッシュのディレクトリ（getCacheDir 配下）
+const mediaCacheDirName = "media"
+
+// 以前のバージョンがプレビュー用の一時ファイルを置いていたディレクトリ
+const legacyImagesDirName = "images"
+
+// キャッシュのメタデータファイルの拡張子
+const cacheMetaSuffix = ".json"
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local thumbnail and media cache",
+	Long:  "Show, prune or clear the on-disk cache used by view previews and downloads",
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the cache location, number of entries and total size",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runCacheStats(); err != nil {
+			return fmt.Errorf("Error reading cache: %w", err)
+		}
+		return nil
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Evict least recently used entries until the cache fits the size limit",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		maxSize, _ := cmd.Flags().GetString("max-size")
+		if err := runCachePrune(maxSize); err != nil {
+			return fmt.Errorf("Error pruning cache: %w", err)
+		}
+		return nil
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove every cached file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runCacheClear(); err != nil {
+			return fmt.Errorf("Error clearing cache: %w", err)
+		}
+		return nil
+	},
+}
+
+// メディアアイテムの ID とサイズ指定（=d、=w512-h512 など）をキーにしたキャッシュ
+// ファイル名はキーのハッシュで、メタデータを同じ名前の .json に保存する
+type mediaCache struct {
+	dir     string
+	maxSize int64
+	// 同じプロセス内の並列ダウンロードで削除が競合しないようにする
+	mu sync.Mutex
+	// 合計サイズ（-1 の場合は未計算）
+	total int64
+}
+
+// キャッシュの1件分のメタデータ
+type cacheEntry struct {
+	MediaID string `json:"mediaId"`
+	Variant string `json:"variant"`
+	// メディアアイテムの元のファイル名
+	Filename string `json:"filename"`
+	// キャッシュディレクトリ内のファイル名
+	File       string    `json:"file"`
+	MimeType   string    `json:"mimeType,omitempty"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastAccess time.Time `json:"lastAccess"`
+}
+
+// 設定（cache.max_size）からキャッシュを開く
+func openMediaCache() (*mediaCache, error) {
+	config, _, err := loadLayeredConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := parseByteSize(config.Cache.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache.max_size: %w", err)
+	}
+
+	cacheDir, err := getCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cacheDir, mediaCacheDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &mediaCache{dir: dir, maxSize: maxSize, total: -1}, nil
+}
+
+// URL から baseUrl を除いたサイズ指定の部分（d、dv、w512-h512 など）
+func mediaVariant(item MediaItem, url string) string {
+	return strings.TrimPrefix(url, item.MediaFile.BaseUrl+"=")
+}
+
+func cacheKey(mediaID, variant string) string {
+	sum := sha256.Sum256([]byte(mediaID + "\x00" + variant))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *mediaCache) metaPath(key string) string {
+	return filepath.Join(c.dir, key+cacheMetaSuffix)
+}
+
+func (c *mediaCache) readEntry(key string) (*cacheEntry, error) {
+	data, err := os.ReadFile(c.metaPath(key))
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// 他のプロセスと一時ファイルが衝突しないよう、書き込みごとに別の一時ファイルを使う
+func (c *mediaCache) writeEntry(key string, entry *cacheEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(c.dir, key+cacheMetaSuffix+".*"+partialFileSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.metaPath(key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// キャッシュにあればそのパスを返し、最終アクセス日時を更新する
+func (c *mediaCache) lookup(mediaID, variant string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path, _, ok := c.lookupLocked(cacheKey(mediaID, variant))
+	return path, ok
+}
+
+// c.mu を保持した状態で呼び出す
+func (c *mediaCache) lookupLocked(key string) (string, *cacheEntry, bool) {
+	entry, err := c.readEntry(key)
+	if err != nil {
+		return "", nil, false
+	}
+	path := filepath.Join(c.dir, entry.File)
+	if info, err := os.Stat(path); err != nil || info.Size() != entry.Size {
+		return "", nil, false
+	}
+
+	entry.LastAccess = time.Now()
+	c.writeEntry(key, entry)
+	return path, entry, true
+}
+
+// キャッシュのキーと保存先のパス
+func (c *mediaCache) filePath(item MediaItem, variant string) (string, string) {
+	key := cacheKey(item.ID, variant)
+	// OS の既定のビューアーで開けるよう拡張子は元のファイル名に合わせる（サムネイルは JPEG）
+	ext := strings.ToLower(filepath.Ext(item.MediaFile.Filename))
+	if ext == "" || strings.HasPrefix(variant, "w") {
+		ext = ".jpg"
+	}
+	return key, filepath.Join(c.dir, key+ext)
+}
+
+// r の内容をキャッシュに保存してパスを返す
+// 上限より大きいものは登録すると他のエントリがすべて削除されるため、キャッシュの外に置いてそのパスを返す
+func (c *mediaCache) store(item MediaItem, variant, mimeType string, r io.Reader) (string, error) {
+	key, path := c.filePath(item, variant)
+	file, err := os.CreateTemp(c.dir, key+".*"+partialFileSuffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache file: %w", err)
+	}
+	tempPath := file.Name()
+	size, err := io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if size > c.maxSize {
+		return storeUncached(tempPath, filepath.Ext(path))
+	}
+
+	now := time.Now()
+	entry := &cacheEntry{
+		MediaID:    item.ID,
+		Variant:    variant,
+		Filename:   item.MediaFile.Filename,
+		File:       filepath.Base(path),
+		MimeType:   mimeType,
+		Size:       size,
+		CreatedAt:  now,
+		LastAccess: now,
+	}
+	if err := c.commit(key, tempPath, entry); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// キャッシュに登録しない一時ファイルを OS の一時ディレクトリに移してパスを返す
+func storeUncached(tempPath, ext string) (string, error) {
+	file, err := os.CreateTemp("", appDirName+"-*"+ext)
+	if err == nil {
+		file.Close()
+		if err = moveFile(tempPath, file.Name()); err != nil {
+			os.Remove(file.Name())
+		}
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// 書き込んだ一時ファイルをキャッシュに登録する
+// 登録後に上限を超えた場合は最近使われていないものから削除する（登録したものは残す）
+func (c *mediaCache) commit(key, tempPath string, entry *cacheEntry) error {
+	path := filepath.Join(c.dir, entry.File)
+	// メタデータのないファイルは evict で削除されるため、まとめて書き込む
+	c.mu.Lock()
+	err := os.Rename(tempPath, path)
+	if err == nil {
+		err = c.writeEntry(key, entry)
+	}
+	if err == nil && c.total >= 0 {
+		c.total += entry.Size
+	}
+	needsEviction := c.total < 0 || c.total > c.maxSize
+	c.mu.Unlock()
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	// 上限を超えるたびに削除しないよう、上限の 90% まで減らす
+	if needsEviction {
+		c.evict(c.maxSize, c.maxSize/10*9, key)
+	}
+	return nil
+}
+
+// キャッシュになければ URL から取得して保存し、パスを返す
+func (c *mediaCache) fetch(ctx context.Context, client *http.Client, item MediaItem, url string) (string, error) {
+	variant := mediaVariant(item, url)
+	if path, ok := c.lookup(item.ID, variant); ok {
+		return path, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError(resp)
+	}
+	return c.store(item, variant, resp.Header.Get("Content-Type"), resp.Body)
+}
+
+// キャッシュにあれば outputPath にコピーする
+// 保存先を編集してもキャッシュが変わらないよう、リンクではなくコピーする
+func (c *mediaCache) copyTo(mediaID, variant, outputPath string) bool {
+	// コピー中に evict で削除されないよう、ロックを保持したままファイルを開く
+	c.mu.Lock()
+	path, _, ok := c.lookupLocked(cacheKey(mediaID, variant))
+	var in *os.File
+	if ok {
+		in, _ = os.Open(path)
+	}
+	c.mu.Unlock()
+	if in == nil {
+		return false
+	}
+	defer in.Close()
+	return copyToFile(in, outputPath) == nil
+}
+
+// ダウンロード済みのファイルをキャッシュにコピーする
+// 上限より大きいファイルは、他のエントリをすべて削除することになるためコピーしない
+func (c *mediaCache) addFile(item MediaItem, variant, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err != nil {
+		return err
+	} else if info.Size() > c.maxSize {
+		return fmt.Errorf("%s is larger than the cache size limit (%s)", filepath.Base(path), formatByteSize(c.maxSize))
+	}
+	_, err = c.store(item, variant, "", file)
+	return err
+}
+
+// メタデータの一覧（最近使われていない順）
+func (c *mediaCache) entries() (map[string]*cacheEntry, []string, error) {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	entries := map[string]*cacheEntry{}
+	var keys []string
+	for _, file := range files {
+		key, ok := strings.CutSuffix(file.Name(), cacheMetaSuffix)
+		if !ok {
+			continue
+		}
+		if entry, err := c.readEntry(key); err == nil {
+			entries[key] = entry
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return entries[a].LastAccess.Compare(entries[b].LastAccess)
+	})
+	return entries, keys, nil
+}
+
+// 合計サイズが limit を超えている場合、target 以下になるまで最近使われていないものから削除する
+// メタデータのないファイル（書き込み途中で中断したものなど）も削除する
+func (c *mediaCache) evict(limit, target int64, keep string) (removed int, freed int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, keys, err := c.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	known := map[string]bool{}
+	for key, entry := range entries {
+		known[entry.File] = true
+		known[key+cacheMetaSuffix] = true
+	}
+	for _, file := range files {
+		info, err := file.Info()
+		if known[file.Name()] || err != nil {
+			continue
+		}
+		// 他のプロセスが書き込み中の可能性がある新しい一時ファイルは残す
+		if strings.HasSuffix(file.Name(), partialFileSuffix) && time.Since(info.ModTime()) < time.Hour {
+			continue
+		}
+		if os.Remove(filepath.Join(c.dir, file.Name())) == nil {
+			freed += info.Size()
+		}
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	if total <= limit {
+		target = total
+	}
+	for _, key := range keys {
+		if total <= target {
+			break
+		}
+		if key == keep {
+			continue
+		}
+		entry := entries[key]
+		os.Remove(filepath.Join(c.dir, entry.File))
+		os.Remove(c.metaPath(key))
+		total -= entry.Size
+		freed += entry.Size
+		removed++
+	}
+	c.total = total
+	return removed, freed, nil
+}
+
+// in の内容を dst にコピー（.part に書き込んでからリネーム）
+func copyToFile(in io.Reader, dst string) error {
+	tempPath := dst + partialFileSuffix
+	out, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, dst)
+}
+
+// 500MB、2GiB、1048576 などのサイズ指定を解析（K/M/G は 1024 倍）
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	for i, unit := range []string{"K", "M", "G", "T"} {
+		if rest, ok := strings.CutSuffix(s, unit); ok {
+			s = rest
+			multiplier = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 500MB, 2GiB)", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+func validateByteSize(value string) error {
+	_, err := parseByteSize(value)
+	return err
+}
+
+// 1.5 GiB のような表示用の文字列
+func formatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
+
+func runCacheStats() error {
+	cache, err := openMediaCache()
+	if err != nil {
+		return err
+	}
+	entries, keys, err := cache.entries()
+	if err != nil {
+		return err
+	}
+
+	var total, thumbnails int64
+	for _, entry := range entries {
+		total += entry.Size
+		if strings.HasPrefix(entry.Variant, "w") {
+			thumbnails++
+		}
+	}
+
+	fmt.Printf("📂 キャッシュ: %s\n", cache.dir)
+	fmt.Printf("件数: %d件 (サムネイル %d件 / オリジナル %d件)\n", len(entries), thumbnails, int64(len(entries))-thumbnails)
+	fmt.Printf("合計サイズ: %s / 上限 %s\n", formatByteSize(total), formatByteSize(cache.maxSize))
+	if len(keys) > 0 {
+		fmt.Printf("最終アクセス: %s 〜 %s\n",
+			entries[keys[0]].LastAccess.Local().Format("2006-01-02 15:04:05"),
+			entries[keys[len(keys)-1]].LastAccess.Local().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+// maxSize が空の場合は cache.max_size の上限まで削除する
+func runCachePrune(maxSize string) error {
+	cache, err := openMediaCache()
+	if err != nil {
+		return err
+	}
+	limit := cache.maxSize
+	if maxSize != "" {
+		if limit, err = parseByteSize(maxSize); err != nil {
+			return err
+		}
+	}
+
+	removed, freed, err := cache.evict(limit, limit, "")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🧹 %d件を削除しました (%s)\n", removed, formatByteSize(freed))
+	return nil
+}
+
+func runCacheClear() error {
+	cacheDir, err := getCacheDir()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{mediaCacheDirName, legacyImagesDirName} {
+		if err := os.RemoveAll(filepath.Join(cacheDir, name)); err != nil {
+			return fmt.Errorf("failed to remove cache: %w", err)
+		}
+	}
+	fmt.Printf("🗑️  キャッシュを削除しました: %s\n", cacheDir)
+	return nil
+}
+
+func init() {
+	cachePruneCmd.Flags().String("max-size", "", "Size to prune down to, e.g. 200MB (default: cache.max_size)")
+
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testCacheItem(id string) MediaItem {
+	return MediaItem{ID: id, MediaFile: MediaFile{BaseUrl: "https://example.com/" + id, Filename: id + ".HEIC"}}
+}
+
+func TestMediaCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	setTestHome(t)
+	t.Setenv("GPHOTO_CACHE_MAX_SIZE", "250")
+
+	cache, err := openMediaCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("x"), 100)
+	store := func(id string) {
+		t.Helper()
+		if _, err := cache.store(testCacheItem(id), "d", "image/heic", bytes.NewReader(data)); err != nil {
+			t.Fatalf("store %s: %v", id, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	store("a")
+	store("b")
+	// a を使うと b が最も古くなる
+	path, ok := cache.lookup("a", "d")
+	if !ok || filepath.Ext(path) != ".heic" {
+		t.Fatalf("lookup a = %q, %t", path, ok)
+	}
+	time.Sleep(10 * time.Millisecond)
+	store("c")
+
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.lookup(id, "d"); ok != want {
+			t.Errorf("%s cached = %t, want %t", id, ok, want)
+		}
+	}
+	if _, ok := cache.lookup("a", "w512-h512"); ok {
+		t.Error("a different size variant should not hit the cache")
+	}
+
+	entry, err := cache.readEntry(cacheKey("c", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.MediaID != "c" || entry.Variant != "d" || entry.Filename != "c.HEIC" || entry.Size != 100 || entry.MimeType != "image/heic" {
+		t.Errorf("metadata = %+v", entry)
+	}
+}
+
+func TestMediaCacheConcurrentLookup(t *testing.T) {
+	setTestHome(t)
+
+	cache, err := openMediaCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.store(testCacheItem("a"), "d", "", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 並列ダウンロードと同じく複数の goroutine から参照・削除しても壊れない
+	outputDir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				cache.lookup("a", "d")
+				cache.copyTo("a", "d", filepath.Join(outputDir, fmt.Sprintf("%d.jpg", i)))
+				cache.evict(1<<30, 1<<30, "")
+			}
+		}()
+	}
+	wg.Wait()
+
+	entry, err := cache.readEntry(cacheKey("a", "d"))
+	if err != nil || entry.Size != int64(len("content")) {
+		t.Fatalf("metadata after concurrent access = %+v, %v", entry, err)
+	}
+	files, _ := os.ReadDir(cache.dir)
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), partialFileSuffix) {
+			t.Errorf("leftover temp file %s", file.Name())
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(outputDir, "0.jpg")); string(data) != "content" {
+		t.Errorf("copied content = %q", data)
+	}
+}
+
+func TestMediaCacheFailedCommitKeepsTotal(t *testing.T) {
+	setTestHome(t)
+
+	cache, err := openMediaCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.store(testCacheItem("a"), "d", "", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	total := cache.total
+
+	// 保存先にディレクトリがあるとリネームできずに失敗する
+	_, path := cache.filePath(testCacheItem("b"), "d")
+	if err := os.MkdirAll(filepath.Join(path, "blocker"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.store(testCacheItem("b"), "d", "", strings.NewReader("content")); err == nil {
+		t.Fatal("store should fail when the cache file cannot be renamed")
+	}
+	if cache.total != total {
+		t.Errorf("total after a failed store = %d, want %d", cache.total, total)
+	}
+}
+
+func TestCachePruneAndClear(t *testing.T) {
+	setTestHome(t)
+
+	cache, err := openMediaCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := cache.store(testCacheItem(id), "d", "", strings.NewReader(strings.Repeat(id, 1000))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// メタデータのない古いファイル（中断した書き込みなど）
+	orphan := filepath.Join(cache.dir, "orphan.jpg.part")
+	if err := os.WriteFile(orphan, []byte("orphan"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(orphan, old, old)
+
+	if err := runCachePrune("2KB"); err != nil {
+		t.Fatalf("cache prune: %v", err)
+	}
+	entries, _, err := cache.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d entries after prune, want 2", len(entries))
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("prune should remove files without metadata")
+	}
+
+	if err := runCacheStats(); err != nil {
+		t.Fatalf("cache stats: %v", err)
+	}
+	if err := runCacheClear(); err != nil {
+		t.Fatalf("cache clear: %v", err)
+	}
+	if _, err := os.Stat(cache.dir); !os.IsNotExist(err) {
+		t.Error("cache clear should remove the cache directory")
+	}
+}
+
+func TestRunDownloadOnlyUsesCache(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	outputDir := setupFakeEnv(t, fake, nil)
+
+	for i, dir := range []string{"first", "second"} {
+		opts := downloadOptions{OutputDir: filepath.Join(outputDir, dir), Concurrency: 2, OnConflict: conflictRename, UseCache: true}
+		if err := runDownloadOnly(opts); err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+		data, err := os.ReadFile(filepath.Join(opts.OutputDir, "IMG_0001.JPG"))
+		if err != nil || !bytes.Equal(data, fake.content["item-1"]) {
+			t.Errorf("download %d: unexpected content (err=%v)", i, err)
+		}
+	}
+	if got := fake.mediaRequests["item-1=d"]; got != 1 {
+		t.Errorf("fetched item-1 %d times, want 1 (the second download should use the cache)", got)
+	}
+
+	// 保存先を編集してもキャッシュの内容は変わらない
+	edited := filepath.Join(outputDir, "first", "IMG_0001.JPG")
+	if err := os.WriteFile(edited, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := openMediaCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path, ok := cache.lookup("item-1", "d"); !ok {
+		t.Error("item-1 should still be cached")
+	} else if data, _ := os.ReadFile(path); !bytes.Equal(data, fake.content["item-1"]) {
+		t.Error("editing a download should not change the cached copy")
+	}
+
+	// --cache を指定しない場合はキャッシュを使わない
+	opts := downloadOptions{OutputDir: filepath.Join(outputDir, "third"), Concurrency: 2, OnConflict: conflictRename}
+	if err := runDownloadOnly(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.mediaRequests["item-1=d"]; got != 2 {
+		t.Errorf("fetched item-1 %d times, want 2 without UseCache", got)
+	}
+}
+
+func TestMediaCacheSkipsFilesLargerThanLimit(t *testing.T) {
+	setTestHome(t)
+	t.Setenv("GPHOTO_CACHE_MAX_SIZE", "100")
+
+	cache, err := openMediaCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "large.jpg")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.addFile(testCacheItem("large"), "d", path); err == nil {
+		t.Error("addFile should refuse a file larger than cache.max_size")
+	}
+	if _, ok := cache.lookup("large", "d"); ok {
+		t.Error("a file larger than cache.max_size should not be cached")
+	}
+}
+
+func TestMediaCacheFetchDoesNotCacheLargerThanLimit(t *testing.T) {
+	fake := newFakePickerServer(t, 1)
+	setupFakeEnv(t, fake, nil)
+	t.Setenv("GPHOTO_CACHE_MAX_SIZE", "1000")
+
+	cache, err := openMediaCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.store(testCacheItem("small"), "d", "", strings.NewReader("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	// オリジナルは上限（1000バイト）より大きい
+	item := fake.items[0]
+	path, err := cache.fetch(context.Background(), fake.authClient(fake.accessToken), item, getMediaDownloadURL(item, false))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, fake.content[item.ID]) {
+		t.Errorf("fetched file has unexpected content (err=%v)", err)
+	}
+	if filepath.Dir(path) == cache.dir {
+		t.Errorf("a file larger than cache.max_size should be kept outside the cache, got %s", path)
+	}
+	if _, ok := cache.lookup(item.ID, "d"); ok {
+		t.Error("a file larger than cache.max_size should not be cached")
+	}
+	if _, ok := cache.lookup("small", "d"); !ok {
+		t.Error("other entries should not be evicted by a file larger than cache.max_size")
+	}
+}
+
+func TestMediaCacheFetchCanceled(t *testing.T) {
+	fake := newFakePickerServer(t, 1)
+	setupFakeEnv(t, fake, nil)
+
+	cache, err := openMediaCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := fake.items[0]
+	url := getImageThumbnailURL(item.MediaFile.BaseUrl, previewImageSize, previewImageSize)
+
+	// view で Ctrl-C を押した場合と同じく、中断されたコンテキストでは取得しない
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.fetch(ctx, fake.authClient(fake.accessToken), item, url); !errors.Is(err, context.Canceled) {
+		t.Fatalf("fetch with a canceled context = %v, want context.Canceled", err)
+	}
+
+	path, err := cache.fetch(context.Background(), fake.authClient(fake.accessToken), item, url)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if _, err := cache.fetch(context.Background(), fake.authClient(fake.accessToken), item, url); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.mediaRequests[item.ID+"="+mediaVariant(item, url)]; got != 1 {
+		t.Errorf("fetched %s %d times, want 1", path, got)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{input: "1048576", want: 1 << 20},
+		{input: "500MB", want: 500 << 20},
+		{input: "2GiB", want: 2 << 30},
+		{input: "1.5g", want: 3 << 29},
+		{input: "64 KB", want: 64 << 10},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.input, got, err, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "0", "-1MB", "lots"} {
+		if _, err := parseByteSize(input); err == nil {
+			t.Errorf("parseByteSize(%q) should fail", input)
+		}
+	}
+}
@@ -16,8 +16,68 @@ type Config struct {
 	GoogleRedirectURI  string `yaml:"google_redirect_uri"`
 	GoogleScope        string `yaml:"google_scope"`
 	AuthMethod         string `yaml:"auth_method"`
+	// 認証情報の保存先（keyring / encrypted-file / plaintext、空の場合は plaintext）
+	CredentialStore string `yaml:"credential_store,omitempty"`
+	// picker コマンドの出力形式（text / json / ndjson / csv / yaml）
+	OutputFormat string `yaml:"output_format,omitempty"`
+	// view コマンドのプレビューの描画方式（auto / kitty / iterm2 / sixel / truecolor / ascii）
+	Renderer string `yaml:"renderer,omitempty"`
+	// ASCII プレビューの色（none / 256 / truecolor）
+	ASCIIColor string `yaml:"ascii_color,omitempty"`
+	// 1文字の縦横比（縦/横、0 の場合はターミナルから取得）
+	CellAspect float64 `yaml:"cell_aspect,omitempty"`
+	// HEIF を PNG に変換するコマンド（空の場合は heif-convert / magick / sips を自動検出）
+	HEIFDecoder string `yaml:"heif_decoder,omitempty"`
+	// download 系コマンドのデフォルト（フラグで上書き可能）
+	Download DownloadDefaults `yaml:"download,omitempty"`
+	// プレビュー・ダウンロードのキャッシュ
+	Cache CacheSettings `yaml:"cache,omitempty"`
+	// API エンドポイント（空の場合は Google の本番エンドポイント）
+	PickerAPIBaseURL string `yaml:"picker_api_base_url,omitempty"`
+	AuthURL          string `yaml:"auth_url,omitempty"`
+	TokenURL         string `yaml:"token_url,omitempty"`
+	DeviceAuthURL    string `yaml:"device_auth_url,omitempty"`
+	RevokeURL        string `yaml:"revoke_url,omitempty"`
+	TokenInfoURL     string `yaml:"tokeninfo_url,omitempty"`
 }
 
+// プロファイルごとの download 系コマンドのデフォルト
+type DownloadDefaults struct {
+	OutputDir    string `yaml:"output_dir,omitempty"`
+	Concurrency  int    `yaml:"concurrency,omitempty"`
+	OnConflict   string `yaml:"on_conflict,omitempty"`
+	NameTemplate string `yaml:"name_template,omitempty"`
+	// 保存時に変換する形式（jpeg / png / webp、空の場合は変換しない）と品質
+	Convert string `yaml:"convert,omitempty"`
+	Quality int    `yaml:"quality,omitempty"`
+}
+
+// キャッシュの設定
+type CacheSettings struct {
+	// 合計サイズの上限（500MB、2GiB など）
+	MaxSize string `yaml:"max_size,omitempty"`
+}
+
+// Google の本番エンドポイント
+const (
+	defaultPickerAPIBaseURL = "https://photospicker.googleapis.com/v1"
+	defaultAuthURL          = "https://accounts.google.com/o/oauth2/auth"
+	defaultTokenURL         = "https://oauth2.googleapis.com/token"
+	defaultDeviceAuthURL    = "https://oauth2.googleapis.com/device/code"
+	defaultRevokeURL        = "https://oauth2.googleapis.com/revoke"
+	defaultTokenInfoURL     = "https://oauth2.googleapis.com/tokeninfo"
+)
+
+// --picker-api-url などのグローバルフラグで指定されたエンドポイント
+var (
+	flagPickerAPIBaseURL string
+	flagAuthURL          string
+	flagTokenURL         string
+	flagDeviceAuthURL    string
+	flagRevokeURL        string
+	flagTokenInfoURL     string
+)
+
 // デフォルト設定
 func getDefaultConfig() *Config {
 	return &Config{
@@ -29,41 +89,86 @@ func getDefaultConfig() *Config {
 
 // 設定ファイルのパスを取得
 func getConfigPath() (string, error) {
-	homeDir, err := os.UserHomeDir()
+	configDir, err := getConfigDir()
 	if err != nil {
-		return "", fmt.Errorf("failed to get home directory: %v", err)
+		return "", err
 	}
 
-	configDir := filepath.Join(homeDir, ".gphoto-cli")
+	return filepath.Join(configDir, "config.yaml"), nil
+}
 
-	// ディレクトリが存在しない場合は作成
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create config directory: %v", err)
+// 設定ファイル全体（プロファイルごとの設定）
+type configFile struct {
+	CurrentProfile string             `yaml:"current_profile,omitempty"`
+	Profiles       map[string]*Config `yaml:"profiles"`
+}
+
+// 設定ファイルを読み込み
+// プロファイルに分かれていない以前の形式の場合は default プロファイルとして扱う
+func loadConfigFile() (*configFile, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return nil, err
 	}
 
-	return filepath.Join(configDir, "config.yaml"), nil
+	cf := &configFile{}
+
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		cf.Profiles = map[string]*Config{}
+		return cf, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cf); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if cf.Profiles == nil {
+		legacy := &Config{}
+		if err := yaml.Unmarshal(data, legacy); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+		cf.Profiles = map[string]*Config{}
+		if *legacy != (Config{}) {
+			cf.Profiles[defaultProfileName] = legacy
+		}
+	}
+
+	return cf, nil
 }
 
-// 設定を読み込み
-func loadConfig() (*Config, error) {
+// 設定ファイルを保存
+func saveConfigFile(cf *configFile) error {
 	configPath, err := getConfigPath()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// ファイルが存在しない場合はデフォルト設定を返す
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return getDefaultConfig(), nil
+	data, err := yaml.Marshal(cf)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	data, err := os.ReadFile(configPath)
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return nil
+}
+
+// 使用中のプロファイルの設定を読み込み
+func loadConfig() (*Config, error) {
+	cf, err := loadConfigFile()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %v", err)
+		return nil, err
 	}
 
-	config := &Config{}
-	if err := yaml.Unmarshal(data, config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	// プロファイルが存在しない場合はデフォルト設定を返す
+	config, ok := cf.Profiles[activeProfileName(cf)]
+	if !ok {
+		return getDefaultConfig(), nil
 	}
 
 	// デフォルト値を設定
@@ -80,23 +185,44 @@ func loadConfig() (*Config, error) {
 	return config, nil
 }
 
-// 設定を保存
-func saveConfig(config *Config) error {
-	configPath, err := getConfigPath()
+// 使用中のプロファイルの設定をデフォルト値を補わずに読み込み
+// 設定ファイルに書かれている値だけを書き換えて保存したい場合に使う
+func loadProfileConfig() (*Config, error) {
+	cf, err := loadConfigFile()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	data, err := yaml.Marshal(config)
+	config, ok := cf.Profiles[activeProfileName(cf)]
+	if !ok {
+		return &Config{}, nil
+	}
+	return config, nil
+}
+
+// 環境変数・フラグを反映したエンドポイントの設定を取得
+func getEndpointConfig() *Config {
+	config, _, err := loadLayeredConfig(nil)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %v", err)
+		return configDefaults()
 	}
+	return config
+}
 
-	if err := os.WriteFile(configPath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write config file: %v", err)
+// Picker API のベースURLを取得
+func getPickerAPIBaseURL() string {
+	return getEndpointConfig().PickerAPIBaseURL
+}
+
+// 使用中のプロファイルの設定を保存
+func saveConfig(config *Config) error {
+	cf, err := loadConfigFile()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	cf.Profiles[activeProfileName(cf)] = config
+	return saveConfigFile(cf)
 }
 
 // 対話式セットアップ
@@ -114,7 +240,7 @@ func runInteractiveSetup() error {
 	fmt.Println("2. 新しいプロジェクトを作成または既存のプロジェクトを選択")
 	fmt.Println("3. APIs & Services > Credentials で 'OAuth 2.0 Client ID' を作成")
 	fmt.Println("   - アプリケーションの種類: デスクトップアプリケーション")
-	fmt.Println("   - 承認済みのリダイレクト URI: http://localhost:8080/auth/callback")
+	fmt.Println("   - 認証時は 127.0.0.1 の空いているポートで一時的にコールバックを受け付けます")
 	fmt.Println("4. クライアント ID とクライアント シークレットをメモ")
 	fmt.Println()
 	fmt.Println("💡 注意: Google Photos Picker APIは特別な有効化は不要で、")
@@ -131,7 +257,7 @@ func runInteractiveSetup() error {
 	fmt.Print("Google Client ID を入力してください: ")
 	clientID, err := reader.ReadString('\n')
 	if err != nil {
-		return fmt.Errorf("failed to read client ID: %v", err)
+		return fmt.Errorf("failed to read client ID: %w", err)
 	}
 	config.GoogleClientID = strings.TrimSpace(clientID)
 
@@ -143,7 +269,7 @@ func runInteractiveSetup() error {
 	fmt.Print("Google Client Secret を入力してください: ")
 	clientSecret, err := reader.ReadString('\n')
 	if err != nil {
-		return fmt.Errorf("failed to read client secret: %v", err)
+		return fmt.Errorf("failed to read client secret: %w", err)
 	}
 	config.GoogleClientSecret = strings.TrimSpace(clientSecret)
 
@@ -155,28 +281,62 @@ func runInteractiveSetup() error {
 	fmt.Println()
 	fmt.Println("認証方式を選択してください:")
 	fmt.Println("1. 自動認証 (推奨): ローカルサーバーを使用")
-	fmt.Println("2. 手動認証: 認証コードを手動で入力")
+	fmt.Println("2. デバイス認証: 別の端末のブラウザでコードを入力 (SSH・ヘッドレス環境向け)")
 	fmt.Print("選択 (1 または 2) default[1]: ")
 
 	authChoice, err := reader.ReadString('\n')
 	if err != nil {
-		return fmt.Errorf("failed to read auth method: %v", err)
+		return fmt.Errorf("failed to read auth method: %w", err)
 	}
 	authChoice = strings.TrimSpace(authChoice)
 
 	if authChoice == "" || authChoice == "1" {
 		config.AuthMethod = "server"
 	} else if authChoice == "2" {
-		config.AuthMethod = "oob"
-		config.GoogleRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+		config.AuthMethod = "device"
 	} else {
 		fmt.Println("無効な選択です。自動認証を使用します。")
 		config.AuthMethod = "server"
 	}
 
+	// 認証情報の保存先の選択
+	fmt.Println()
+	fmt.Println("トークンとクライアントシークレットの保存先を選択してください:")
+	defaultStore := "3"
+	if keyringAvailable() {
+		defaultStore = "1"
+		fmt.Println("1. OS キーリング (推奨): Secret Service / キーチェーン")
+	} else {
+		fmt.Println("1. OS キーリング: Secret Service / キーチェーン (このシステムでは利用できません)")
+	}
+	fmt.Println("2. 暗号化ファイル: パスフレーズで暗号化 (age)")
+	fmt.Println("3. 平文ファイル: 設定ディレクトリに保存")
+	fmt.Printf("選択 (1, 2 または 3) default[%s]: ", defaultStore)
+
+	storeChoice, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read credential store: %w", err)
+	}
+	storeChoice = strings.TrimSpace(storeChoice)
+	if storeChoice == "" {
+		storeChoice = defaultStore
+	}
+
+	switch storeChoice {
+	case "1":
+		config.CredentialStore = credentialStoreKeyring
+	case "2":
+		config.CredentialStore = credentialStoreEncryptedFile
+	case "3":
+		config.CredentialStore = credentialStorePlaintext
+	default:
+		fmt.Println("無効な選択です。平文ファイルを使用します。")
+		config.CredentialStore = credentialStorePlaintext
+	}
+
 	// 設定を保存
-	if err := saveConfig(config); err != nil {
-		return fmt.Errorf("failed to save config: %v", err)
+	if err := saveConfigWithCredentials(config); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
 	}
 
 	configPath, _ := getConfigPath()
@@ -193,8 +353,9 @@ func runInteractiveSetup() error {
 }
 
 // 設定の確認
-func runConfigShow() error {
-	config, err := loadConfig()
+// showSource が true の場合はすべての項目を値の出どころと共に表示する
+func runConfigShow(showSource bool) error {
+	config, sources, err := loadLayeredConfig(nil)
 	if err != nil {
 		return err
 	}
@@ -202,39 +363,62 @@ func runConfigShow() error {
 	configPath, _ := getConfigPath()
 
 	fmt.Printf("📍 設定ファイル: %s\n", configPath)
+	fmt.Printf("プロファイル: %s\n", currentProfileName())
 	fmt.Println()
+	if showSource {
+		printConfigSources(config, sources)
+		return nil
+	}
+
 	fmt.Printf("Google Client ID: %s\n", maskString(config.GoogleClientID))
-	fmt.Printf("Google Client Secret: %s\n", maskString(config.GoogleClientSecret))
+	if config.GoogleClientSecret == "" && credentialStoreName(config) != credentialStorePlaintext {
+		fmt.Println("Google Client Secret: (認証情報ストアに保存)")
+	} else {
+		fmt.Printf("Google Client Secret: %s\n", maskString(config.GoogleClientSecret))
+	}
 	fmt.Printf("Redirect URI: %s\n", config.GoogleRedirectURI)
 	fmt.Printf("認証方式: %s\n", config.AuthMethod)
 	fmt.Printf("OAuth Scope: %s\n", config.GoogleScope)
+	if store, err := newCredentialStore(credentialStoreName(config), currentProfileName()); err != nil {
+		fmt.Printf("認証情報の保存先: %v\n", err)
+	} else {
+		fmt.Printf("認証情報の保存先: %s\n", store.Name())
+	}
+	fmt.Printf("出力形式: %s\n", config.OutputFormat)
+	fmt.Printf("ダウンロード先: %s\n", config.Download.OutputDir)
+	fmt.Printf("同時ダウンロード数: %d\n", config.Download.Concurrency)
+	fmt.Printf("同名ファイルの扱い: %s\n", config.Download.OnConflict)
+	if config.Download.NameTemplate != "" {
+		fmt.Printf("ファイル名テンプレート: %s\n", config.Download.NameTemplate)
+	}
+	if config.Download.Convert != "" {
+		fmt.Printf("変換: %s (品質 %d)\n", config.Download.Convert, config.Download.Quality)
+	}
+	fmt.Printf("Picker API URL: %s\n", config.PickerAPIBaseURL)
+	fmt.Printf("Auth URL: %s\n", config.AuthURL)
+	fmt.Printf("Token URL: %s\n", config.TokenURL)
+	fmt.Printf("Device Auth URL: %s\n", config.DeviceAuthURL)
+	fmt.Printf("Revoke URL: %s\n", config.RevokeURL)
+	fmt.Printf("Token Info URL: %s\n", config.TokenInfoURL)
 
 	return nil
 }
 
-// 設定のリセット
+// 使用中のプロファイルの設定と認証情報をリセット
 func runConfigReset() error {
-	configPath, err := getConfigPath()
+	cf, err := loadConfigFile()
 	if err != nil {
 		return err
 	}
 
-	// 設定ファイルを削除
-	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove config file: %v", err)
-	}
-
-	// トークンファイルも削除
-	tokenPath, err := getTokenPath()
-	if err != nil {
-		fmt.Printf("Warning: failed to get token path: %v\n", err)
-	} else {
-		if err := os.Remove(tokenPath); err != nil && !os.IsNotExist(err) {
-			fmt.Printf("Warning: failed to remove token file: %v\n", err)
+	name := activeProfileName(cf)
+	if _, ok := cf.Profiles[name]; ok {
+		if err := deleteProfile(cf, name); err != nil {
+			return err
 		}
 	}
 
-	fmt.Println("✅ 設定がリセットされました")
+	fmt.Printf("✅ 設定がリセットされました (プロファイル: %s)\n", name)
 	fmt.Println("再度セットアップを行うには: ./gphoto-cli setup")
 
 	return nil
@@ -250,10 +434,12 @@ func maskString(s string) string {
 
 // 設定が完了しているかチェック
 func isConfigured() bool {
-	config, err := loadConfig()
+	config, _, err := loadLayeredConfig(nil)
 	if err != nil {
 		return false
 	}
 
-	return config.GoogleClientID != "" && config.GoogleClientSecret != ""
+	// 平文ファイル以外ではクライアントシークレットは認証情報ストアにある
+	hasSecret := config.GoogleClientSecret != "" || (credentialStoreName(config) != credentialStorePlaintext && hasStoredClientSecret(config))
+	return config.GoogleClientID != "" && hasSecret
 }
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// download --convert の変換先
+const (
+	convertJPEG = "jpeg"
+	convertPNG  = "png"
+	convertWebP = "webp"
+)
+
+// 変換後の拡張子
+var convertExtensions = map[string]string{
+	convertJPEG: ".jpg",
+	convertPNG:  ".png",
+	convertWebP: ".webp",
+}
+
+// 変換前のファイルを保存する場合の拡張子（変換後に削除）
+const convertSourceSuffix = ".orig"
+
+// WebP のエンコードは外部コマンド（libwebp の cwebp）を使う
+var errNoWebPEncoder = errors.New("webp conversion requires cwebp (libwebp) in PATH")
+
+func validateConvertFormat(format string) error {
+	if _, ok := convertExtensions[format]; !ok {
+		return fmt.Errorf("invalid convert format %q (want jpeg, png or webp)", format)
+	}
+	return nil
+}
+
+func validateQuality(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 100 {
+		return fmt.Errorf("quality must be between 1 and 100: %q", value)
+	}
+	return nil
+}
+
+// 画像を指定した形式に変換して保存する（Exif は引き継ぐ）
+// 変換元が既に同じ形式の場合はそのままコピーする
+func convertImageFile(srcPath, dstPath, format string, quality int) error {
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	out := data
+	if sniffImageFormat(data) != format {
+		img, srcFormat, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode image: %w", err)
+		}
+
+		exif, err := extractExif(data, srcFormat)
+		if err != nil {
+			return fmt.Errorf("failed to read Exif: %w", err)
+		}
+		// HEIF のデコーダーは回転を適用済みなので Exif の向きは「回転なし」にする
+		if srcFormat == "heif" {
+			exif = setExifOrientation(exif, 1)
+		}
+
+		if out, err = encodeImage(img, format, quality, exif); err != nil {
+			return err
+		}
+	}
+
+	// 書き込み途中のファイルが残らないよう一時ファイル経由で保存
+	tempPath := dstPath + partialFileSuffix
+	if err := os.WriteFile(tempPath, out, 0644); err != nil {
+		return fmt.Errorf("failed to write converted image: %w", err)
+	}
+	if err := os.Rename(tempPath, dstPath); err != nil {
+		return fmt.Errorf("failed to finalize file: %w", err)
+	}
+	return nil
+}
+
+// 変換先の形式のいずれかであればその名前を返す
+func sniffImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return convertJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return convertPNG
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return convertWebP
+	}
+	return ""
+}
+
+// Exif（TIFF ヘッダーから始まるバイト列）を埋め込んでエンコード
+func encodeImage(img image.Image, format string, quality int, exif []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case convertJPEG:
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+		}
+		return insertJPEGExif(buf.Bytes(), exif), nil
+	case convertPNG:
+		// PNG は可逆圧縮なので quality は使わない
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode PNG: %w", err)
+		}
+		return insertPNGExif(buf.Bytes(), exif), nil
+	case convertWebP:
+		data, err := encodeWebP(img, quality)
+		if err != nil {
+			return nil, err
+		}
+		return insertWebPExif(data, img.Bounds(), exif)
+	}
+	return nil, validateConvertFormat(format)
+}
+
+// cwebp で WebP にエンコード
+func encodeWebP(img image.Image, quality int) ([]byte, error) {
+	if _, err := exec.LookPath("cwebp"); err != nil {
+		return nil, errNoWebPEncoder
+	}
+
+	tempDir, err := os.MkdirTemp("", "gphoto-cli-webp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	input := filepath.Join(tempDir, "input.png")
+	output := filepath.Join(tempDir, "output.webp")
+	data, err := encodePNG(img)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	cmd := exec.Command("cwebp", "-quiet", "-q", strconv.Itoa(quality), input, "-o", output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("cwebp failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(output)
+}
+
+// 画像の形式ごとに Exif を取り出す（ない場合は nil）
+func extractExif(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return extractJPEGExif(data), nil
+	case "png":
+		return extractPNGExif(data), nil
+	case "webp":
+		return extractWebPExif(data), nil
+	case "heif":
+		return extractHEIFExif(data)
+	}
+	return nil, nil
+}
+
+// JPEG の APP1 セグメントの Exif 識別子
+var jpegExifHeader = []byte("Exif\x00\x00")
+
+func extractJPEGExif(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xff; {
+		marker := data[pos+1]
+		// SOS 以降は画像データ
+		if marker == 0xda {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		if segment := data[pos+4 : end]; marker == 0xe1 && bytes.HasPrefix(segment, jpegExifHeader) {
+			return segment[len(jpegExifHeader):]
+		}
+		pos = end
+	}
+	return nil
+}
+
+// SOI の直後に APP1 セグメントとして挿入
+// セグメントの上限（64KB）を超える Exif は JPEG には格納できないため省略する
+func insertJPEGExif(data, exif []byte) []byte {
+	length := 2 + len(jpegExifHeader) + len(exif)
+	if len(exif) == 0 || length > 0xffff {
+		return data
+	}
+
+	out := make([]byte, 0, len(data)+2+length)
+	out = append(out, data[:2]...)
+	out = append(out, 0xff, 0xe1)
+	out = binary.BigEndian.AppendUint16(out, uint16(length))
+	out = append(out, jpegExifHeader...)
+	out = append(out, exif...)
+	return append(out, data[2:]...)
+}
+
+// PNG のシグネチャ（8バイト）
+const pngSignatureSize = 8
+
+func extractPNGExif(data []byte) []byte {
+	for pos := pngSignatureSize; pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		if chunkType == "eXIf" {
+			return data[pos+8 : pos+8+length]
+		}
+		pos = end
+	}
+	return nil
+}
+
+// IHDR チャンクの直後に eXIf チャンクとして挿入
+func insertPNGExif(data, exif []byte) []byte {
+	if len(exif) == 0 || len(data) < pngSignatureSize+8 {
+		return data
+	}
+	ihdrEnd := pngSignatureSize + 12 + int(binary.BigEndian.Uint32(data[pngSignatureSize:]))
+
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(exif)))
+	chunk = append(chunk, "eXIf"...)
+	chunk = append(chunk, exif...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+// RIFF のチャンク
+type riffChunk struct {
+	Type string
+	Data []byte
+}
+
+func readWebPChunks(data []byte) ([]riffChunk, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP file")
+	}
+	var chunks []riffChunk
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size
+		if size < 0 || end > len(data) {
+			return nil, fmt.Errorf("invalid WebP chunk size")
+		}
+		chunks = append(chunks, riffChunk{Type: string(data[pos : pos+4]), Data: data[pos+8 : end]})
+		// チャンクは2バイト境界に揃えられる
+		pos = end + size%2
+	}
+	return chunks, nil
+}
+
+func extractWebPExif(data []byte) []byte {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return nil
+	}
+	for _, chunk := range chunks {
+		if chunk.Type == "EXIF" {
+			// "Exif\0\0" を付けて保存するソフトウェアもある
+			return bytes.TrimPrefix(chunk.Data, jpegExifHeader)
+		}
+	}
+	return nil
+}
+
+// VP8X ヘッダーの Exif フラグ
+const webpExifFlag = 0x08
+
+// EXIF チャンクを追加（単純形式の場合は拡張形式の VP8X ヘッダーを付ける）
+func insertWebPExif(data []byte, bounds image.Rectangle, exif []byte) ([]byte, error) {
+	if len(exif) == 0 {
+		return data, nil
+	}
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunks) == 0 || chunks[0].Type != "VP8X" {
+		// キャンバスサイズは 24bit で「幅-1」「高さ-1」を格納する
+		vp8x := make([]byte, 10)
+		putUint24LE(vp8x[4:], uint32(bounds.Dx()-1))
+		putUint24LE(vp8x[7:], uint32(bounds.Dy()-1))
+		chunks = append([]riffChunk{{Type: "VP8X", Data: vp8x}}, chunks...)
+	}
+	vp8x := append([]byte(nil), chunks[0].Data...)
+	vp8x[0] |= webpExifFlag
+	chunks[0].Data = vp8x
+	chunks = append(chunks, riffChunk{Type: "EXIF", Data: exif})
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for _, chunk := range chunks {
+		body.WriteString(chunk.Type)
+		binary.Write(&body, binary.LittleEndian, uint32(len(chunk.Data)))
+		body.Write(chunk.Data)
+		if len(chunk.Data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(body.Len()))
+	return append(out, body.Bytes()...), nil
+}
+
+func putUint24LE(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// Exif の向き（Orientation タグ）
+const exifOrientationTag = 0x0112
+
+// IFD0 の Orientation を書き換えたコピーを返す（タグがない場合はそのまま）
+func setExifOrientation(exif []byte, orientation uint16) []byte {
+	if len(exif) < 8 {
+		return exif
+	}
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return exif
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd+2 > len(exif) {
+		return exif
+	}
+	count := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(exif) {
+			break
+		}
+		// 型が SHORT（3）の場合のみ値はエントリ内に格納される
+		if order.Uint16(exif[entry:]) == exifOrientationTag && order.Uint16(exif[entry+2:]) == 3 {
+			out := append([]byte(nil), exif...)
+			order.PutUint16(out[entry+8:], orientation)
+			return out
+		}
+	}
+	return exif
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Orientation タグだけを持つ Exif（リトルエンディアンの TIFF）
+func testExif(orientation uint16) []byte {
+	exif := []byte("II*\x00")
+	exif = binary.LittleEndian.AppendUint32(exif, 8)
+	exif = binary.LittleEndian.AppendUint16(exif, 1)
+	exif = binary.LittleEndian.AppendUint16(exif, exifOrientationTag)
+	exif = binary.LittleEndian.AppendUint16(exif, 3)
+	exif = binary.LittleEndian.AppendUint32(exif, 1)
+	exif = binary.LittleEndian.AppendUint16(exif, orientation)
+	exif = append(exif, 0, 0)
+	return binary.LittleEndian.AppendUint32(exif, 0)
+}
+
+func testImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 8), B: 64, A: 255})
+		}
+	}
+	return img
+}
+
+func testJPEGWithExif(t *testing.T, exif []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(32, 16), nil); err != nil {
+		t.Fatal(err)
+	}
+	return insertJPEGExif(buf.Bytes(), exif)
+}
+
+// 外部コマンドの代わりに使うシェルスクリプトを作成
+func writeTestScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not available on Windows")
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConvertImageFileKeepsExif(t *testing.T) {
+	exif := testExif(6)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.jpg")
+	if err := os.WriteFile(src, testJPEGWithExif(t, exif), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// JPEG → PNG → JPEG の順に変換しても Exif（向きを含む）が残る
+	pngPath := filepath.Join(dir, "out.png")
+	if err := convertImageFile(src, pngPath, convertPNG, 90); err != nil {
+		t.Fatalf("convert to png: %v", err)
+	}
+	data, _ := os.ReadFile(pngPath)
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("converted PNG does not decode: %v", err)
+	}
+	if got := extractPNGExif(data); !bytes.Equal(got, exif) {
+		t.Errorf("PNG Exif = %x, want %x", got, exif)
+	}
+
+	jpegPath := filepath.Join(dir, "out.jpg")
+	if err := convertImageFile(pngPath, jpegPath, convertJPEG, 50); err != nil {
+		t.Fatalf("convert to jpeg: %v", err)
+	}
+	data, _ = os.ReadFile(jpegPath)
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("converted JPEG does not decode: %v", err)
+	}
+	if got := extractJPEGExif(data); !bytes.Equal(got, exif) {
+		t.Errorf("JPEG Exif = %x, want %x", got, exif)
+	}
+
+	// 同じ形式への変換はそのままコピー
+	copyPath := filepath.Join(dir, "copy.jpg")
+	if err := convertImageFile(jpegPath, copyPath, convertJPEG, 10); err != nil {
+		t.Fatal(err)
+	}
+	if copied, _ := os.ReadFile(copyPath); !bytes.Equal(copied, data) {
+		t.Error("converting to the same format should keep the file as is")
+	}
+}
+
+// Exif アイテムだけを持つ最小限の HEIF
+func testHEIF(exif []byte) []byte {
+	box := func(boxType string, payload ...[]byte) []byte {
+		data := bytes.Join(payload, nil)
+		out := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+		return append(append(out, boxType...), data...)
+	}
+
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	infe := box("infe", []byte{2, 0, 0, 0, 0, 1, 0, 0}, []byte("Exif"), []byte{0})
+	iinf := box("iinf", []byte{0, 0, 0, 0, 0, 1}, infe)
+	item := append([]byte{0, 0, 0, 6}, append(append([]byte(nil), jpegExifHeader...), exif...)...)
+
+	// iloc の長さは固定なのでオフセットを先に計算する
+	ilocSize := 8 + 4 + 2 + 2 + 2 + 2 + 2 + 4 + 4
+	metaSize := 8 + 4 + len(iinf) + ilocSize
+	offset := len(ftyp) + metaSize + 8
+	iloc := box("iloc", []byte{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 1},
+		binary.BigEndian.AppendUint32(nil, uint32(offset)),
+		binary.BigEndian.AppendUint32(nil, uint32(len(item))))
+	meta := box("meta", []byte{0, 0, 0, 0}, iinf, iloc)
+	return bytes.Join([][]byte{ftyp, meta, box("mdat", item)}, nil)
+}
+
+func TestExtractHEIFExif(t *testing.T) {
+	exif := testExif(6)
+	got, err := extractHEIFExif(testHEIF(exif))
+	if err != nil {
+		t.Fatalf("extractHEIFExif: %v", err)
+	}
+	if !bytes.Equal(got, exif) {
+		t.Errorf("Exif = %x, want %x", got, exif)
+	}
+}
+
+func TestConvertHEIFWithExternalDecoder(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+
+	dir := t.TempDir()
+	decoded := filepath.Join(dir, "decoded.png")
+	data, err := encodePNG(testImage(16, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(decoded, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := writeTestScript(t, dir, "fake-heif-convert", fmt.Sprintf("cp %q \"$2\"\n", decoded))
+	setHEIFDecoderCommand(script + " {input} {output}")
+	t.Cleanup(func() { setHEIFDecoderCommand("") })
+	// デコード時には設定を読み直さないので、関係のない設定の誤りは影響しない
+	t.Setenv("GPHOTO_CACHE_MAX_SIZE", "lots")
+
+	src := filepath.Join(dir, "IMG_0001.HEIC")
+	if err := os.WriteFile(src, testHEIF(testExif(6)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// プレビューと同じく image.Decode で HEIF を読み込める
+	file, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, format, err := image.Decode(file)
+	file.Close()
+	if err != nil || format != "heif" || img.Bounds().Dx() != 16 {
+		t.Fatalf("image.Decode = %v, %q, %v", img.Bounds(), format, err)
+	}
+
+	dst := filepath.Join(dir, "IMG_0001.jpg")
+	if err := convertImageFile(src, dst, convertJPEG, 90); err != nil {
+		t.Fatalf("convertImageFile: %v", err)
+	}
+	out, _ := os.ReadFile(dst)
+	// デコーダーが回転を適用済みなので向きは 1 になる
+	if got := extractJPEGExif(out); !bytes.Equal(got, testExif(1)) {
+		t.Errorf("Exif = %x, want orientation reset to 1", got)
+	}
+}
+
+func TestConvertHEIFWithoutDecoder(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+	t.Setenv("PATH", t.TempDir())
+	setHEIFDecoderCommand("")
+	t.Cleanup(func() { setHEIFDecoderCommand("") })
+
+	_, _, err := image.Decode(bytes.NewReader(testHEIF(nil)))
+	if !errors.Is(err, errNoHEIFDecoder) {
+		t.Errorf("image.Decode error = %v, want errNoHEIFDecoder", err)
+	}
+}
+
+func TestConvertToWebPAddsExif(t *testing.T) {
+	dir := t.TempDir()
+	// VP8L の単純形式の WebP を書き出す cwebp の代わり
+	writeTestScript(t, dir, "cwebp", `while [ $# -gt 0 ]; do
+	if [ "$1" = "-o" ]; then out="$2"; fi
+	shift
+done
+printf 'RIFF\016\000\000\000WEBPVP8L\002\000\000\000\057\000' > "$out"
+`)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	exif := testExif(3)
+	src := filepath.Join(dir, "src.jpg")
+	if err := os.WriteFile(src, testJPEGWithExif(t, exif), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "out.webp")
+	if err := convertImageFile(src, dst, convertWebP, 80); err != nil {
+		t.Fatalf("convertImageFile: %v", err)
+	}
+
+	data, _ := os.ReadFile(dst)
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, chunk := range chunks {
+		types = append(types, chunk.Type)
+	}
+	if got := strings.Join(types, ","); got != "VP8X,VP8L,EXIF" {
+		t.Fatalf("chunks = %s, want VP8X,VP8L,EXIF", got)
+	}
+	vp8x := chunks[0].Data
+	if vp8x[0]&webpExifFlag == 0 || vp8x[4] != 31 || vp8x[7] != 15 {
+		t.Errorf("VP8X = %x, want Exif flag and a 32x16 canvas", vp8x)
+	}
+	if got := extractWebPExif(data); !bytes.Equal(got, exif) {
+		t.Errorf("WebP Exif = %x, want %x", got, exif)
+	}
+	if binary.LittleEndian.Uint32(data[4:]) != uint32(len(data)-8) {
+		t.Error("RIFF size does not match the file size")
+	}
+}
+
+func TestRunDownloadOnlyConvert(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	outputDir := setupFakeEnv(t, fake, nil)
+
+	exif := testExif(6)
+	fake.content["item-0"] = testJPEGWithExif(t, exif)
+	pngData, err := encodePNG(testImage(8, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.content["item-1"] = pngData
+
+	opts := downloadOptions{OutputDir: outputDir, Concurrency: 2, OnConflict: conflictRename, Convert: convertPNG, Quality: 90}
+	if err := runDownloadOnly(opts); err != nil {
+		t.Fatalf("runDownloadOnly: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "IMG_0000.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := extractPNGExif(data); !bytes.Equal(got, exif) {
+		t.Errorf("Exif = %x, want %x", got, exif)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outputDir, "IMG_0001.png")); !bytes.Equal(data, pngData) {
+		t.Error("a PNG should be saved without re-encoding")
+	}
+
+	entries, _ := os.ReadDir(outputDir)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), convertSourceSuffix) || strings.HasSuffix(entry.Name(), partialFileSuffix) {
+			t.Errorf("leftover file %s", entry.Name())
+		}
+	}
+}
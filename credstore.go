@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// 認証情報の保存先
+const (
+	credentialStorePlaintext     = "plaintext"
+	credentialStoreKeyring       = "keyring"
+	credentialStoreEncryptedFile = "encrypted-file"
+)
+
+// 認証情報のキー
+const (
+	credentialKeyToken        = "token"
+	credentialKeyClientSecret = "client_secret"
+)
+
+const (
+	keyringService          = "gphoto-cli"
+	encryptedCredentialFile = "credentials.age"
+)
+
+// 保存されていないキーを読み込んだ場合のエラー
+var errCredentialNotFound = errors.New("credential not found")
+
+// scrypt のワークファクター（テストでは小さくして高速化する）
+var ageScryptWorkFactor = 18
+
+// OAuth トークンやクライアントシークレットの保存先
+type credentialStore interface {
+	// config show などに表示する名前
+	Name() string
+	Get(key string) ([]byte, error)
+	Set(key string, data []byte) error
+	Delete(key string) error
+}
+
+var configMigrateCredentialsCmd = &cobra.Command{
+	Use:   "migrate-credentials",
+	Short: "Move stored credentials to another credential store",
+	Long:  "Move the OAuth token (and the client secret) to the OS keyring, an encrypted file or a plaintext file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		to, _ := cmd.Flags().GetString("to")
+		if err := runMigrateCredentials(to); err != nil {
+			return fmt.Errorf("Error migrating credentials: %w", err)
+		}
+		return nil
+	},
+}
+
+func validateCredentialStore(name string) error {
+	switch name {
+	case credentialStorePlaintext, credentialStoreKeyring, credentialStoreEncryptedFile:
+		return nil
+	default:
+		return fmt.Errorf("unknown credential store %q (want %s, %s or %s)",
+			name, credentialStoreKeyring, credentialStoreEncryptedFile, credentialStorePlaintext)
+	}
+}
+
+// 設定ファイルと環境変数 GPHOTO_CREDENTIAL_STORE から保存先の種類を決定（未指定なら平文ファイル）
+func credentialStoreName(config *Config) string {
+	name := config.CredentialStore
+	if envName := os.Getenv("GPHOTO_CREDENTIAL_STORE"); envName != "" {
+		name = envName
+	}
+	if name == "" {
+		name = credentialStorePlaintext
+	}
+	return name
+}
+
+// profile ごとにキーを分けた保存先を作成
+func newCredentialStore(name, profile string) (credentialStore, error) {
+	if err := validateCredentialStore(name); err != nil {
+		return nil, err
+	}
+	if err := validateProfileName(profile); err != nil {
+		return nil, err
+	}
+
+	dataDir, err := getDataDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var store credentialStore
+	switch name {
+	case credentialStoreKeyring:
+		store = keyringStore{}
+	case credentialStoreEncryptedFile:
+		store = &encryptedFileStore{path: filepath.Join(dataDir, encryptedCredentialFile), passphrase: credentialPassphrase}
+	default:
+		store = plaintextStore{dir: dataDir}
+	}
+	return profileScopedStore{credentialStore: store, profile: profile}, nil
+}
+
+// 設定ファイルで選択されている保存先を取得
+func activeCredentialStore() (credentialStore, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newCredentialStore(credentialStoreName(config), currentProfileName())
+}
+
+// Secret Service (D-Bus) などの OS キーリングが利用できるか
+func keyringAvailable() bool {
+	_, err := keyring.Get(keyringService, "availability-check")
+	return err == nil || errors.Is(err, keyring.ErrNotFound)
+}
+
+// 従来どおり設定ディレクトリに平文の JSON として保存する（token は token.json）
+type plaintextStore struct {
+	dir string
+}
+
+func (s plaintextStore) Name() string {
+	return credentialStorePlaintext + " (" + s.dir + ")"
+}
+
+func (s plaintextStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+func (s plaintextStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, errCredentialNotFound
+	}
+	return data, err
+}
+
+func (s plaintextStore) Set(key string, data []byte) error {
+	return os.WriteFile(s.path(key), data, 0600)
+}
+
+func (s plaintextStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// OS のキーリング（Linux では D-Bus 経由の Secret Service、macOS ではキーチェーン）
+type keyringStore struct{}
+
+func (keyringStore) Name() string {
+	return credentialStoreKeyring + " (service: " + keyringService + ")"
+}
+
+func (keyringStore) Get(key string) ([]byte, error) {
+	secret, err := keyring.Get(keyringService, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, errCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from keyring: %w", err)
+	}
+	return []byte(secret), nil
+}
+
+func (keyringStore) Set(key string, data []byte) error {
+	if err := keyring.Set(keyringService, key, string(data)); err != nil {
+		return fmt.Errorf("failed to write to keyring: %w", err)
+	}
+	return nil
+}
+
+func (keyringStore) Delete(key string) error {
+	if err := keyring.Delete(keyringService, key); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("failed to delete from keyring: %w", err)
+	}
+	return nil
+}
+
+// パスフレーズで age (scrypt) 暗号化したファイル
+// すべてのキーを1つの JSON オブジェクトにまとめて暗号化する
+type encryptedFileStore struct {
+	path       string
+	passphrase func() (string, error)
+}
+
+func (s *encryptedFileStore) Name() string {
+	return credentialStoreEncryptedFile + " (" + s.path + ")"
+}
+
+func (s *encryptedFileStore) load() (map[string][]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return map[string][]byte{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials file (wrong passphrase?): %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials file: %w", err)
+	}
+
+	entries := map[string][]byte{}
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *encryptedFileStore) save(entries map[string][]byte) error {
+	if len(entries) == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return err
+	}
+	recipient.SetWorkFactor(ageScryptWorkFactor)
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipient)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+
+	// 書き込み途中で壊れないよう一時ファイルからリネームする
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+func (s *encryptedFileStore) Get(key string) ([]byte, error) {
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	data, ok := entries[key]
+	if !ok {
+		return nil, errCredentialNotFound
+	}
+	return data, nil
+}
+
+func (s *encryptedFileStore) Set(key string, data []byte) error {
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	entries[key] = data
+	return s.save(entries)
+}
+
+func (s *encryptedFileStore) Delete(key string) error {
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := entries[key]; !ok {
+		return nil
+	}
+	delete(entries, key)
+	return s.save(entries)
+}
+
+// 暗号化ファイルのパスフレーズ
+// 環境変数 GPHOTO_CREDENTIAL_PASSPHRASE がなければ端末から入力し、プロセス内では1度だけ尋ねる
+var credentialPassphrase = sync.OnceValues(func() (string, error) {
+	if passphrase := os.Getenv("GPHOTO_CREDENTIAL_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("credentials file is encrypted: set GPHOTO_CREDENTIAL_PASSPHRASE or run in a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, "認証情報のパスフレーズを入力してください: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("passphrase must not be empty")
+	}
+	return string(passphrase), nil
+})
+
+// クライアントシークレットを解決する
+// 平文ファイル以外を使う場合、config.yaml には保存せず認証情報ストアから読み込む
+func resolveClientSecret(config *Config) error {
+	if config.GoogleClientSecret != "" || credentialStoreName(config) == credentialStorePlaintext {
+		return nil
+	}
+
+	store, err := newCredentialStore(credentialStoreName(config), currentProfileName())
+	if err != nil {
+		return err
+	}
+	secret, err := store.Get(credentialKeyClientSecret)
+	if err != nil && !errors.Is(err, errCredentialNotFound) {
+		return err
+	}
+	config.GoogleClientSecret = string(secret)
+	return nil
+}
+
+// 認証情報ストアにクライアントシークレットが保存されているか
+// 暗号化ファイルはパスフレーズを尋ねないよう復号せず、ファイルの有無だけを確認する
+// キーリングが使えないなど、有無を判定できない場合は保存されているものとして後続の処理でエラーを報告する
+func hasStoredClientSecret(config *Config) bool {
+	name := credentialStoreName(config)
+	if name == credentialStoreEncryptedFile {
+		dataDir, err := getDataDir()
+		if err != nil {
+			return false
+		}
+		_, err = os.Stat(filepath.Join(dataDir, encryptedCredentialFile))
+		return err == nil
+	}
+
+	store, err := newCredentialStore(name, currentProfileName())
+	if err != nil {
+		return false
+	}
+	_, err = store.Get(credentialKeyClientSecret)
+	return !errors.Is(err, errCredentialNotFound)
+}
+
+// 設定を保存する（平文ファイル以外を使う場合はクライアントシークレットを認証情報ストアに移す）
+// 保存先は環境変数ではなく config.CredentialStore に従う
+func saveConfigWithCredentials(config *Config) error {
+	name := config.CredentialStore
+	if name == "" {
+		name = credentialStorePlaintext
+	}
+	if name == credentialStorePlaintext || config.GoogleClientSecret == "" {
+		return saveConfig(config)
+	}
+
+	store, err := newCredentialStore(name, currentProfileName())
+	if err != nil {
+		return err
+	}
+	if err := store.Set(credentialKeyClientSecret, []byte(config.GoogleClientSecret)); err != nil {
+		return fmt.Errorf("failed to store client secret: %w", err)
+	}
+
+	stored := *config
+	stored.GoogleClientSecret = ""
+	return saveConfig(&stored)
+}
+
+// 既存の認証情報を別の保存先に移行する
+func runMigrateCredentials(to string) error {
+	if err := validateCredentialStore(to); err != nil {
+		return err
+	}
+	if to == credentialStoreKeyring && !keyringAvailable() {
+		return errors.New("OS keyring (Secret Service) is not available on this system")
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	from := credentialStoreName(config)
+	if from == to {
+		fmt.Printf("認証情報は既に %s に保存されています\n", to)
+		return nil
+	}
+
+	profile := currentProfileName()
+	src, err := newCredentialStore(from, profile)
+	if err != nil {
+		return err
+	}
+	dst, err := newCredentialStore(to, profile)
+	if err != nil {
+		return err
+	}
+
+	// トークンを移行
+	token, err := src.Get(credentialKeyToken)
+	switch {
+	case errors.Is(err, errCredentialNotFound):
+		fmt.Println("保存済みのトークンはありません（次回のログイン時に新しい保存先へ保存されます）")
+	case err != nil:
+		return fmt.Errorf("failed to read token from %s: %w", src.Name(), err)
+	default:
+		if err := dst.Set(credentialKeyToken, token); err != nil {
+			return fmt.Errorf("failed to write token to %s: %w", dst.Name(), err)
+		}
+	}
+
+	// クライアントシークレットを移行（平文の場合は config.yaml に戻す）
+	if err := resolveClientSecret(config); err != nil {
+		return err
+	}
+	config.CredentialStore = to
+	if err := saveConfigWithCredentials(config); err != nil {
+		return err
+	}
+
+	// 移行元から削除
+	if token != nil {
+		if err := src.Delete(credentialKeyToken); err != nil {
+			fmt.Printf("Warning: failed to delete token from %s: %v\n", src.Name(), err)
+		}
+	}
+	if from != credentialStorePlaintext {
+		if err := src.Delete(credentialKeyClientSecret); err != nil {
+			fmt.Printf("Warning: failed to delete client secret from %s: %v\n", src.Name(), err)
+		}
+	}
+
+	fmt.Printf("✅ 認証情報を移行しました: %s → %s\n", src.Name(), dst.Name())
+	if os.Getenv("GPHOTO_CREDENTIAL_STORE") != "" {
+		fmt.Println("⚠️  環境変数 GPHOTO_CREDENTIAL_STORE が設定されているため、設定ファイルより優先されます")
+	}
+	return nil
+}
+
+func init() {
+	configMigrateCredentialsCmd.Flags().String("to", "", "Destination credential store: "+strings.Join([]string{credentialStoreKeyring, credentialStoreEncryptedFile, credentialStorePlaintext}, ", "))
+	configMigrateCredentialsCmd.MarkFlagRequired("to")
+
+	configCmd.AddCommand(configMigrateCredentialsCmd)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestCredentialStores(t *testing.T) {
+	keyring.MockInit()
+	ageScryptWorkFactor = 10
+
+	dir := t.TempDir()
+	stores := map[string]credentialStore{
+		credentialStorePlaintext: plaintextStore{dir: dir},
+		credentialStoreKeyring:   keyringStore{},
+		credentialStoreEncryptedFile: &encryptedFileStore{
+			path:       filepath.Join(dir, encryptedCredentialFile),
+			passphrase: func() (string, error) { return "correct horse", nil },
+		},
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.Get(credentialKeyToken); !errors.Is(err, errCredentialNotFound) {
+				t.Fatalf("Get before Set: err = %v, want errCredentialNotFound", err)
+			}
+
+			want := []byte(`{"access_token":"secret"}`)
+			if err := store.Set(credentialKeyToken, want); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			got, err := store.Get(credentialKeyToken)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("Get = %q, %v; want %q", got, err, want)
+			}
+
+			if err := store.Delete(credentialKeyToken); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if err := store.Delete(credentialKeyToken); err != nil {
+				t.Fatalf("Delete of a missing key should succeed: %v", err)
+			}
+			if _, err := store.Get(credentialKeyToken); !errors.Is(err, errCredentialNotFound) {
+				t.Fatalf("Get after Delete: err = %v, want errCredentialNotFound", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedFileStoreDoesNotLeakPlaintext(t *testing.T) {
+	ageScryptWorkFactor = 10
+
+	path := filepath.Join(t.TempDir(), encryptedCredentialFile)
+	store := &encryptedFileStore{path: path, passphrase: func() (string, error) { return "correct horse", nil }}
+	if err := store.Set(credentialKeyToken, []byte("refresh-token-value")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("refresh-token-value")) {
+		t.Error("credentials file contains the plaintext token")
+	}
+
+	wrong := &encryptedFileStore{path: path, passphrase: func() (string, error) { return "battery staple", nil }}
+	if _, err := wrong.Get(credentialKeyToken); err == nil {
+		t.Error("Get with a wrong passphrase should fail")
+	}
+}
+
+func TestMigrateCredentials(t *testing.T) {
+	keyring.MockInit()
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+
+	before, err := loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runMigrateCredentials(credentialStoreKeyring); err != nil {
+		t.Fatalf("runMigrateCredentials: %v", err)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CredentialStore != credentialStoreKeyring {
+		t.Errorf("credential_store = %q, want %q", config.CredentialStore, credentialStoreKeyring)
+	}
+	if config.GoogleClientSecret != "" {
+		t.Error("client secret should be removed from config.yaml")
+	}
+	dataDir, err := getDataDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (plaintextStore{dir: dataDir}).Get(credentialKeyToken); !errors.Is(err, errCredentialNotFound) {
+		t.Errorf("plaintext token.json should be removed, err = %v", err)
+	}
+
+	after, err := loadToken()
+	if err != nil {
+		t.Fatalf("loadToken after migration: %v", err)
+	}
+	if after.RefreshToken != before.RefreshToken {
+		t.Errorf("refresh token = %q, want %q", after.RefreshToken, before.RefreshToken)
+	}
+
+	oauthConfig, err := getGoogleConfig()
+	if err != nil {
+		t.Fatalf("getGoogleConfig: %v", err)
+	}
+	if oauthConfig.ClientSecret != "test-client-secret" {
+		t.Errorf("client secret = %q, want it to be read from the keyring", oauthConfig.ClientSecret)
+	}
+
+	// 平文に戻すとクライアントシークレットは config.yaml に戻る
+	if err := runMigrateCredentials(credentialStorePlaintext); err != nil {
+		t.Fatalf("migrate back: %v", err)
+	}
+	config, err = loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.GoogleClientSecret != "test-client-secret" {
+		t.Errorf("client secret in config.yaml = %q", config.GoogleClientSecret)
+	}
+	if _, err := loadToken(); err != nil {
+		t.Errorf("loadToken after migrating back: %v", err)
+	}
+}
+
+func TestConfigSetClearsStoredClientSecret(t *testing.T) {
+	keyring.MockInit()
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+
+	if err := runMigrateCredentials(credentialStoreKeyring); err != nil {
+		t.Fatalf("runMigrateCredentials: %v", err)
+	}
+	if err := runConfigSet("google_client_secret", ""); err != nil {
+		t.Fatalf("config set google_client_secret '': %v", err)
+	}
+
+	store, err := activeCredentialStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(credentialKeyClientSecret); !errors.Is(err, errCredentialNotFound) {
+		t.Errorf("client secret should be removed from the keyring, err = %v", err)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.GoogleClientSecret != "" {
+		t.Errorf("client secret = %q, want it to be cleared", config.GoogleClientSecret)
+	}
+}
+
+func TestIsConfiguredChecksStoredClientSecret(t *testing.T) {
+	keyring.MockInit()
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+
+	if err := runMigrateCredentials(credentialStoreKeyring); err != nil {
+		t.Fatalf("runMigrateCredentials: %v", err)
+	}
+	if !isConfigured() {
+		t.Fatal("isConfigured should be true with the client secret in the keyring")
+	}
+
+	// キーリングにシークレットがなければ未設定として扱う
+	store, err := activeCredentialStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(credentialKeyClientSecret); err != nil {
+		t.Fatal(err)
+	}
+	if isConfigured() {
+		t.Error("isConfigured should be false when the keyring has no client secret")
+	}
+}
+
+func mustConfigPath(t *testing.T) string {
+	t.Helper()
+	configPath, err := getConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
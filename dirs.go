@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// XDG Base Directory 配下のアプリケーションディレクトリ名
+const appDirName = "gphoto-cli"
+
+// 以前のバージョンで使っていたディレクトリ（~/.gphoto-cli）
+const legacyDirName = ".gphoto-cli"
+
+// 移行済みであることを示すファイル（設定ディレクトリに作成する）
+const legacyMigratedMarker = ".legacy-migrated"
+
+// --config-dir フラグで指定されたディレクトリ
+var flagConfigDir string
+
+// --config-dir または GPHOTO_CONFIG_DIR で指定されたディレクトリ（未指定の場合は空）
+// 指定された場合は設定・認証情報・セッションをすべてこのディレクトリに置く
+func configDirOverride() string {
+	if flagConfigDir != "" {
+		return flagConfigDir
+	}
+	return os.Getenv("GPHOTO_CONFIG_DIR")
+}
+
+// XDG の環境変数のディレクトリ、未設定（または相対パス）の場合はホームディレクトリからの fallback を使う
+func xdgBaseDir(envName string, fallback ...string) (string, error) {
+	if dir := os.Getenv(envName); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(append([]string{homeDir}, fallback...)...), nil
+}
+
+// アプリケーションのディレクトリを取得（存在しない場合は作成）
+func appDir(envName string, fallback ...string) (string, error) {
+	dir := configDirOverride()
+	if dir == "" {
+		base, err := xdgBaseDir(envName, fallback...)
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(base, appDirName)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	return dir, nil
+}
+
+// 設定ファイルのディレクトリ（$XDG_CONFIG_HOME/gphoto-cli）
+func getConfigDir() (string, error) {
+	return appDir("XDG_CONFIG_HOME", ".config")
+}
+
+// トークンなど認証情報のディレクトリ（$XDG_DATA_HOME/gphoto-cli）
+func getDataDir() (string, error) {
+	return appDir("XDG_DATA_HOME", ".local", "share")
+}
+
+// セッション一覧など実行状態のディレクトリ（$XDG_STATE_HOME/gphoto-cli）
+func getStateDir() (string, error) {
+	return appDir("XDG_STATE_HOME", ".local", "state")
+}
+
+// 画像キャッシュのディレクトリ（$XDG_CACHE_HOME/gphoto-cli）
+func getCacheDir() (string, error) {
+	if dir := configDirOverride(); dir != "" {
+		cacheDir := filepath.Join(dir, "cache")
+		if err := os.MkdirAll(cacheDir, 0700); err != nil {
+			return "", fmt.Errorf("failed to create directory: %w", err)
+		}
+		return cacheDir, nil
+	}
+	return appDir("XDG_CACHE_HOME", ".cache")
+}
+
+// ~/.gphoto-cli があれば XDG のディレクトリに一度だけ移行する
+// 新しい設定ファイルや移行済みのマーカーが既にある場合、--config-dir 指定時は何もしない
+func migrateLegacyDir() error {
+	if configDirOverride() != "" {
+		return nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	legacyDir := filepath.Join(homeDir, legacyDirName)
+	if _, err := os.Stat(legacyDir); err != nil {
+		return nil
+	}
+
+	configPath, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		return nil
+	}
+	// 不明なファイルが残って移行元が削除できなかった場合も、移行は1度だけ行う
+	markerPath := filepath.Join(filepath.Dir(configPath), legacyMigratedMarker)
+	if _, err := os.Stat(markerPath); err == nil {
+		return nil
+	}
+
+	dataDir, err := getDataDir()
+	if err != nil {
+		return err
+	}
+	stateDir, err := getStateDir()
+	if err != nil {
+		return err
+	}
+
+	// 移行元のパターンと移行先
+	moves := []struct {
+		pattern string
+		dir     string
+	}{
+		{"config.yaml", filepath.Dir(configPath)},
+		{"token*.json", dataDir},
+		{"client_secret*.json", dataDir},
+		{encryptedCredentialFile, dataDir},
+		{"sessions.json", stateDir},
+	}
+	for _, move := range moves {
+		matches, _ := filepath.Glob(filepath.Join(legacyDir, move.pattern))
+		for _, src := range matches {
+			if err := moveFile(src, filepath.Join(move.dir, filepath.Base(src))); err != nil {
+				return fmt.Errorf("failed to migrate %s: %w", src, err)
+			}
+		}
+	}
+
+	if err := os.WriteFile(markerPath, []byte(legacyDir+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to record the migration: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "📦 %s の設定を移行しました\n", legacyDir)
+	fmt.Fprintf(os.Stderr, "   設定: %s\n   認証情報: %s\n   セッション: %s\n", filepath.Dir(configPath), dataDir, stateDir)
+	// 不明なファイルが残っている場合はディレクトリを残す
+	if err := os.Remove(legacyDir); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "   移行していないファイルは %s に残しています\n", legacyDir)
+	}
+	return nil
+}
+
+// ファイルを移動（別のファイルシステムの場合はコピーしてから削除）
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&flagConfigDir, "config-dir", "", "Directory for config, credentials and sessions (env: GPHOTO_CONFIG_DIR, default: XDG base directories)")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestXDGDirectories(t *testing.T) {
+	home := setTestHome(t)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg-config"))
+	// 相対パスは XDG の仕様どおり無視する
+	t.Setenv("XDG_CACHE_HOME", "relative/cache")
+
+	tests := []struct {
+		name string
+		get  func() (string, error)
+		want string
+	}{
+		{name: "config", get: getConfigDir, want: filepath.Join(home, "xdg-config", appDirName)},
+		{name: "data", get: getDataDir, want: filepath.Join(home, ".local", "share", appDirName)},
+		{name: "state", get: getStateDir, want: filepath.Join(home, ".local", "state", appDirName)},
+		{name: "cache", get: getCacheDir, want: filepath.Join(home, ".cache", appDirName)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// --config-dir を指定するとすべてそのディレクトリ配下になる
+	override := filepath.Join(home, "portable")
+	flagConfigDir = override
+	t.Cleanup(func() { flagConfigDir = "" })
+	if got, _ := getDataDir(); got != override {
+		t.Errorf("data dir with --config-dir = %s, want %s", got, override)
+	}
+	if got, _ := getCacheDir(); got != filepath.Join(override, "cache") {
+		t.Errorf("cache dir with --config-dir = %s", got)
+	}
+}
+
+func TestMigrateLegacyDir(t *testing.T) {
+	home := setTestHome(t)
+
+	legacyDir := filepath.Join(home, legacyDirName)
+	if err := os.MkdirAll(legacyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"config.yaml":   "google_client_id: legacy-id\n",
+		"token.json":    `{"access_token":"legacy-token"}`,
+		"sessions.json": "[]",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(legacyDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateLegacyDir(); err != nil {
+		t.Fatalf("migrateLegacyDir: %v", err)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.GoogleClientID != "legacy-id" {
+		t.Errorf("client id = %q, want legacy-id", config.GoogleClientID)
+	}
+	tok, err := loadToken()
+	if err != nil {
+		t.Fatalf("loadToken: %v", err)
+	}
+	if tok.AccessToken != "legacy-token" {
+		t.Errorf("access token = %q, want legacy-token", tok.AccessToken)
+	}
+	sessionsPath, err := getSessionsPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sessionsPath); err != nil {
+		t.Errorf("sessions.json not migrated: %v", err)
+	}
+	if _, err := os.Stat(legacyDir); !os.IsNotExist(err) {
+		t.Errorf("legacy directory should be removed, err = %v", err)
+	}
+
+	// 2回目以降は何もしない
+	if err := migrateLegacyDir(); err != nil {
+		t.Fatalf("second migrateLegacyDir: %v", err)
+	}
+}
+
+func TestMigrateLegacyDirOnlyOnceWithUnknownFiles(t *testing.T) {
+	home := setTestHome(t)
+
+	// 設定ファイルがなく、移行しないファイルだけが残っている
+	legacyDir := filepath.Join(home, legacyDirName)
+	if err := os.MkdirAll(legacyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacyDir, "notes.txt"), []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacyDir, "sessions.json"), []byte("[]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateLegacyDir(); err != nil {
+		t.Fatalf("migrateLegacyDir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(legacyDir, "notes.txt")); err != nil {
+		t.Errorf("unknown files should be left in place: %v", err)
+	}
+
+	// 2回目は移行しない（移行元に新しく置かれたファイルもそのまま）
+	if err := os.WriteFile(filepath.Join(legacyDir, "sessions.json"), []byte("[]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateLegacyDir(); err != nil {
+		t.Fatalf("second migrateLegacyDir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(legacyDir, "sessions.json")); err != nil {
+		t.Errorf("migration should run only once, sessions.json was moved again: %v", err)
+	}
+}
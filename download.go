@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 中断したダウンロードを再開するための選択内容の保存先（出力ディレクトリ内）
+const downloadManifestName = ".gphoto-cli-download.json"
+
+// ダウンロード途中のファイルに付ける拡張子
+const partialFileSuffix = ".part"
+
+// モーションフォトの動画部分が存在しない場合のエラー（失敗としては扱わない）
+var errNotMotionPhoto = errors.New("not a motion photo")
+
+// 一時的なエラーでダウンロードを再試行する回数
+const maxDownloadRetries = 3
+
+// 再試行の最初の待ち時間（2回目以降は倍にする）
+var downloadRetryDelay = initialRetryDelay
+
+// 中断したダウンロードを再開するための情報
+type downloadManifest struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Thumbnail bool      `json:"thumbnail"`
+	// 写真を変換して保存する場合の形式と品質
+	Convert string `json:"convert,omitempty"`
+	Quality int    `json:"quality,omitempty"`
+	// ダウンロード完了後に削除する Picker セッション
+	SessionName string                  `json:"sessionName,omitempty"`
+	Entries     []downloadManifestEntry `json:"entries"`
+}
+
+// マニフェストに記録する1件分（Path は出力ディレクトリからの相対パス）
+type downloadManifestEntry struct {
+	Item        MediaItem `json:"item"`
+	Path        string    `json:"path"`
+	MotionVideo bool      `json:"motionVideo,omitempty"`
+	// 最終的なファイル名へのリネームまで完了したか
+	// 上書きする既存のファイルと区別するため、ファイルの有無ではなくこのフラグで再開時にスキップする
+	Done bool `json:"done,omitempty"`
+}
+
+// ダウンロード対象の1件分
+type downloadJob struct {
+	Index      int
+	Item       MediaItem
+	URL        string
+	OutputPath string
+	// モーションフォトの動画部分を取得するジョブかどうか
+	MotionVideo bool
+	// 写真を変換して保存する場合の形式（空の場合はそのまま保存）と品質
+	Convert string
+	Quality int
+	// 取得済みのファイルを再利用するキャッシュ（nil の場合は使わない）
+	Cache *mediaCache
+}
+
+// ダウンロード結果の1件分
+type downloadResult struct {
+	Job downloadJob
+	Err error
+}
+
+// 同時実行数を制限したワーカープールでダウンロードする
+// onResult は jobs の順番通りに呼び出される
+func downloadAll(ctx context.Context, client *http.Client, jobs []downloadJob, concurrency int, onResult func(downloadResult)) []downloadResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(jobs) {
+		concurrency = len(jobs)
+	}
+
+	jobCh := make(chan downloadJob)
+	resultCh := make(chan downloadResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				err := ctx.Err()
+				if err == nil {
+					err = job.runWithRetry(ctx, client)
+				}
+				resultCh <- downloadResult{Job: job, Err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobCh)
+		for _, job := range jobs {
+			jobCh <- job
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	// 完了順ではなく jobs の順番で結果を通知する
+	results := make([]downloadResult, len(jobs))
+	done := make([]bool, len(jobs))
+	next := 0
+	for result := range resultCh {
+		results[result.Job.Index] = result
+		done[result.Job.Index] = true
+		for next < len(jobs) && done[next] {
+			if onResult != nil {
+				onResult(results[next])
+			}
+			next++
+		}
+	}
+
+	return results
+}
+
+// 一時的なエラーの場合は .part ファイルを残したまま再試行し、続きから取得する
+func (job downloadJob) runWithRetry(ctx context.Context, client *http.Client) error {
+	for attempt := 0; ; attempt++ {
+		err := job.run(ctx, client)
+		if attempt >= maxDownloadRetries || !isTransientDownloadError(ctx, err) {
+			return err
+		}
+
+		delay := min(downloadRetryDelay<<attempt, maxRetryBackoff)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			delay = min(apiErr.RetryAfter, maxRetryBackoff)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// 再試行すれば成功する可能性のあるエラーか（5xx/429、接続の切断など）
+func isTransientDownloadError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (job downloadJob) run(ctx context.Context, client *http.Client) error {
+	if job.MotionVideo {
+		return job.fetch(ctx, client, job.OutputPath, downloadMotionVideoToFile)
+	}
+	if err := checkVideoProcessingStatus(job.Item); err != nil {
+		return err
+	}
+	if job.Convert != "" && job.Item.Type == mediaTypePhoto {
+		return job.downloadAndConvert(ctx, client)
+	}
+	return job.fetch(ctx, client, job.OutputPath, downloadImageToFile)
+}
+
+// キャッシュにあればコピーし、なければダウンロードしてキャッシュに追加する
+// キャッシュへの追加に失敗してもダウンロード自体は成功として扱う
+func (job downloadJob) fetch(ctx context.Context, client *http.Client, outputPath string, download func(context.Context, *http.Client, string, string) error) error {
+	if job.Cache == nil {
+		return download(ctx, client, job.URL, outputPath)
+	}
+
+	variant := mediaVariant(job.Item, job.URL)
+	if job.Cache.copyTo(job.Item.ID, variant, outputPath) {
+		return nil
+	}
+	if err := download(ctx, client, job.URL, outputPath); err != nil {
+		return err
+	}
+	job.Cache.addFile(job.Item, variant, outputPath)
+	return nil
+}
+
+// 元の形式のままダウンロードしてから変換する
+// 変換に失敗した場合は元のファイルを残す
+func (job downloadJob) downloadAndConvert(ctx context.Context, client *http.Client) error {
+	sourcePath := job.OutputPath + convertSourceSuffix
+	if err := job.fetch(ctx, client, sourcePath, downloadImageToFile); err != nil {
+		return err
+	}
+	if err := convertImageFile(sourcePath, job.OutputPath, job.Convert, job.Quality); err != nil {
+		return fmt.Errorf("failed to convert to %s (original kept at %s): %w", job.Convert, sourcePath, err)
+	}
+	return os.Remove(sourcePath)
+}
+
+// 動画がまだダウンロードできない状態かを確認
+func checkVideoProcessingStatus(item MediaItem) error {
+	if item.Type != mediaTypeVideo {
+		return nil
+	}
+
+	switch item.MediaFile.MediaFileMetadata.VideoMetadata.ProcessingStatus {
+	case videoProcessingStatusProcessing:
+		return fmt.Errorf("video is still being processed by Google Photos; try again later")
+	case videoProcessingStatusFailed:
+		return fmt.Errorf("video processing failed on Google Photos")
+	}
+	return nil
+}
+
+// 失敗したダウンロードの一覧を表示し、失敗件数を返す
+func printDownloadSummary(results []downloadResult) int {
+	var failed []downloadResult
+	total := 0
+	for _, result := range results {
+		// モーションフォトでなかった写真の動画部分は集計しない
+		if errors.Is(result.Err, errNotMotionPhoto) {
+			continue
+		}
+		total++
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("📊 成功: %d件 / 失敗: %d件 (合計 %d件)\n", total-len(failed), len(failed), total)
+
+	if len(failed) > 0 {
+		fmt.Println("\n❌ 失敗したダウンロード:")
+		for _, result := range failed {
+			fmt.Printf("   - %s (ID: %s): %v\n", result.Job.Item.MediaFile.Filename, result.Job.Item.ID, result.Err)
+		}
+	}
+
+	return len(failed)
+}
+
+// 画像（または動画）をダウンロードしてファイルに保存する
+func downloadImageToFile(ctx context.Context, client *http.Client, imageUrl, outputPath string) error {
+	return downloadToFile(ctx, client, imageUrl, outputPath, nil)
+}
+
+// モーションフォトの動画部分をダウンロードする
+// レスポンスが動画でない場合は errNotMotionPhoto を返す
+func downloadMotionVideoToFile(ctx context.Context, client *http.Client, videoUrl, outputPath string) error {
+	return downloadToFile(ctx, client, videoUrl, outputPath, func(resp *http.Response) error {
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "video/") {
+			return errNotMotionPhoto
+		}
+		return nil
+	})
+}
+
+// URLの内容をファイルに保存する
+// 書き込みは .part ファイルに行い、完了後にリネームする
+// 既に .part ファイルがある場合は Range リクエストで続きから再開する
+// checkResponse が指定されている場合は書き込み前にレスポンスを検証する
+func downloadToFile(ctx context.Context, client *http.Client, imageUrl, outputPath string, checkResponse func(*http.Response) error) error {
+	// ディレクトリの存在と権限を確認
+	dir := filepath.Dir(outputPath)
+	if stat, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("directory not accessible: %w", err)
+	} else if !stat.IsDir() {
+		return fmt.Errorf("path is not a directory: %s", dir)
+	}
+
+	partPath := outputPath + partialFileSuffix
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	// 画像をダウンロード
+	req, err := http.NewRequestWithContext(ctx, "GET", imageUrl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// 認証ヘッダーは client（getClient で作成）が付ける
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Range 非対応のサーバーは全体を返すので最初から書き直す
+		flags |= os.O_TRUNC
+	case http.StatusPartialContent:
+		start, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			os.Remove(partPath)
+			return fmt.Errorf("unexpected Content-Range %q for resumed download", resp.Header.Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// .part ファイルが既に全体を含んでいる場合は完了として扱う
+		if _, total, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && total == offset {
+			return os.Rename(partPath, outputPath)
+		}
+		os.Remove(partPath)
+		return fmt.Errorf("failed to resume download: status %d (partial file discarded)", resp.StatusCode)
+	default:
+		if checkResponse != nil && (resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotFound) {
+			// モーションフォトでない写真に =dv を付けた場合などはクライアントエラーになる
+			if err := checkResponse(resp); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("failed to download image: %w", newAPIError(resp))
+	}
+
+	if checkResponse != nil {
+		if err := checkResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	// .part ファイルに保存
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		// より詳細なエラー情報を提供
+		if os.IsPermission(err) {
+			return fmt.Errorf("permission denied: cannot create file %s (check directory permissions)", partPath)
+		}
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+
+	// 完了したファイルを最終的な名前にリネーム
+	if err := os.Rename(partPath, outputPath); err != nil {
+		return fmt.Errorf("failed to finalize file: %w", err)
+	}
+
+	return nil
+}
+
+// Content-Range ヘッダー（bytes start-end/total または bytes */total）を解析
+// total が不明（*）の場合は -1 を返す
+func parseContentRange(value string) (start, total int64, err error) {
+	rangeSpec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", value)
+	}
+
+	span, size, ok := strings.Cut(rangeSpec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", value)
+	}
+
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range: %q", value)
+		}
+	}
+
+	if span == "*" {
+		return 0, total, nil
+	}
+
+	first, _, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", value)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", value)
+	}
+
+	return start, total, nil
+}
+
+// 選択内容を出力ディレクトリに保存
+func saveDownloadManifest(outputDir string, manifest *downloadManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	// 書き込み途中で中断されても壊れないよう一時ファイル経由で保存
+	manifestPath := filepath.Join(outputDir, downloadManifestName)
+	tempPath := manifestPath + partialFileSuffix
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return os.Rename(tempPath, manifestPath)
+}
+
+// 出力ディレクトリから選択内容を読み込み
+func loadDownloadManifest(outputDir string) (*downloadManifest, error) {
+	manifestPath := filepath.Join(outputDir, downloadManifestName)
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no interrupted download found in %s", outputDir)
+		}
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	manifest := &downloadManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// Picker の baseUrl は約60分で失効し、動画の処理状況も変わるため、セッションから取得し直したアイテムに差し替える
+// セッションに含まれないアイテムは保存されている内容のまま残す
+func refreshManifestItems(ctx context.Context, pickerClient *PickerClient, manifest *downloadManifest) error {
+	items, err := pickerClient.ListMediaItems(ctx, manifest.SessionName)
+	if err != nil {
+		return err
+	}
+	latest := make(map[string]MediaItem, len(items))
+	for _, item := range items {
+		latest[item.ID] = item
+	}
+	for i, entry := range manifest.Entries {
+		if item, ok := latest[entry.Item.ID]; ok {
+			manifest.Entries[i].Item = item
+		}
+	}
+	return nil
+}
+
+// ダウンロード完了後にマニフェストを削除
+func removeDownloadManifest(outputDir string) error {
+	err := os.Remove(filepath.Join(outputDir, downloadManifestName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
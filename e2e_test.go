@@ -0,0 +1,777 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestListMediaItemsPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    int
+		pageSize int
+	}{
+		{name: "server default page size", items: 5, pageSize: 0},
+		{name: "one item per page", items: 3, pageSize: 1},
+		{name: "single page", items: 7, pageSize: 100},
+		{name: "empty selection", items: 0, pageSize: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakePickerServer(t, tt.items)
+			fake.pollsUntilSet = 0
+
+			pickerClient := NewPickerClient(fake.authClient(fake.accessToken))
+			pickerClient.SetBaseURL(fake.URL + "/v1")
+			pickerClient.SetPageSize(tt.pageSize)
+
+			ctx := context.Background()
+			session, err := pickerClient.CreateSession(ctx)
+			if err != nil {
+				t.Fatalf("CreateSession: %v", err)
+			}
+
+			items, err := pickerClient.ListMediaItems(ctx, session.Name)
+			if err != nil {
+				t.Fatalf("ListMediaItems: %v", err)
+			}
+			if len(items) != tt.items {
+				t.Fatalf("got %d items, want %d", len(items), tt.items)
+			}
+			for i, item := range items {
+				if item.ID != fake.items[i].ID {
+					t.Errorf("item %d: got ID %q, want %q", i, item.ID, fake.items[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestListMediaItemsRepeatedPageToken(t *testing.T) {
+	fake := newFakePickerServer(t, 3)
+	fake.repeatPageToken = true
+
+	pickerClient := NewPickerClient(fake.authClient(fake.accessToken))
+	pickerClient.SetBaseURL(fake.URL + "/v1")
+
+	ctx := context.Background()
+	session, err := pickerClient.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// 同じトークンが返され続けても無限ループにならない
+	if _, err := pickerClient.ListMediaItems(ctx, session.Name); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Fatalf("ListMediaItems with a repeated page token = %v, want an error", err)
+	}
+}
+
+func TestRunPickerEndToEnd(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    int
+		pageSize int
+		keep     bool
+	}{
+		{name: "paginated selection", items: 5, pageSize: 2},
+		{name: "empty selection", items: 0},
+		{name: "keep session", items: 1, keep: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakePickerServer(t, tt.items)
+			setupFakeEnv(t, fake, nil)
+
+			if err := runPicker(pickerOptions{PageSize: tt.pageSize, KeepSession: tt.keep}); err != nil {
+				t.Fatalf("runPicker: %v", err)
+			}
+
+			if fake.listedItems != tt.items {
+				t.Errorf("listed %d items, want %d", fake.listedItems, tt.items)
+			}
+
+			wantDeleted := 1
+			if tt.keep {
+				wantDeleted = 0
+			}
+			if len(fake.deletedSessions) != wantDeleted {
+				t.Errorf("deleted %d sessions, want %d", len(fake.deletedSessions), wantDeleted)
+			}
+		})
+	}
+}
+
+func TestRunDownloadOnlyEndToEnd(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      downloadOptions
+		setup     func(fake *fakePickerServer)
+		existing  []string
+		wantFiles map[string]string
+		// 作成されないはずのファイル
+		wantMissing []string
+		// 一部のダウンロードが失敗する場合のエラー
+		wantErr string
+	}{
+		{
+			name: "full resolution in parallel",
+			opts: downloadOptions{Concurrency: 4, OnConflict: conflictRename},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG": "item-0",
+				"IMG_0001.JPG": "item-1",
+				"IMG_0002.JPG": "item-2",
+			},
+		},
+		{
+			name: "thumbnails sequentially",
+			opts: downloadOptions{Concurrency: 1, Thumbnail: true, OnConflict: conflictRename},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG": "thumbnail",
+				"IMG_0002.JPG": "thumbnail",
+			},
+		},
+		{
+			name: "name template",
+			opts: downloadOptions{
+				Concurrency:  2,
+				OnConflict:   conflictRename,
+				NameTemplate: `{{.CreateTime | date "2006/01"}}/{{.CameraModel}}/{{.Filename}}`,
+			},
+			wantFiles: map[string]string{
+				"2024/01/Pixel 8/IMG_0000.JPG": "item-0",
+				"2024/03/Pixel 8/IMG_0002.JPG": "item-2",
+			},
+		},
+		{
+			name:     "rename on conflict",
+			opts:     downloadOptions{Concurrency: 2, OnConflict: conflictRename},
+			existing: []string{"IMG_0001.JPG"},
+			wantFiles: map[string]string{
+				"IMG_0001.JPG":   "existing",
+				"IMG_0001_1.JPG": "item-1",
+			},
+		},
+		{
+			name:     "skip on conflict",
+			opts:     downloadOptions{Concurrency: 2, OnConflict: conflictSkip},
+			existing: []string{"IMG_0001.JPG"},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG": "item-0",
+				"IMG_0001.JPG": "existing",
+			},
+		},
+		{
+			name:     "overwrite on conflict",
+			opts:     downloadOptions{Concurrency: 2, OnConflict: conflictOverwrite},
+			existing: []string{"IMG_0001.JPG"},
+			wantFiles: map[string]string{
+				"IMG_0001.JPG": "item-1",
+			},
+			wantMissing: []string{"IMG_0001_1.JPG"},
+		},
+		{
+			name:     "hash on conflict",
+			opts:     downloadOptions{Concurrency: 2, OnConflict: conflictHash},
+			existing: []string{"IMG_0001.JPG"},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG": "item-0",
+				"IMG_0001.JPG": "existing",
+				"IMG_0001_" + shortHash("item-1") + ".JPG": "item-1",
+			},
+		},
+		{
+			name: "same filename within a batch is renamed",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictRename},
+			setup: func(fake *fakePickerServer) {
+				fake.items[1].MediaFile.Filename = "IMG_0000.JPG"
+				fake.items[2].MediaFile.Filename = "IMG_0000.JPG"
+			},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG":   "item-0",
+				"IMG_0000_1.JPG": "item-1",
+				"IMG_0000_2.JPG": "item-2",
+			},
+		},
+		{
+			name: "skip renames another item with the same name in the batch",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictSkip},
+			setup: func(fake *fakePickerServer) {
+				fake.items[1].MediaFile.Filename = "IMG_0000.JPG"
+			},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG":   "item-0",
+				"IMG_0000_1.JPG": "item-1",
+			},
+		},
+		{
+			name: "overwrite does not clobber another item in the same batch",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictOverwrite},
+			setup: func(fake *fakePickerServer) {
+				fake.items[1].MediaFile.Filename = "IMG_0000.JPG"
+			},
+			existing: []string{"IMG_0000.JPG"},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG":   "item-0",
+				"IMG_0000_1.JPG": "item-1",
+			},
+		},
+		{
+			name:     "hash does not clobber an existing hashed name",
+			opts:     downloadOptions{Concurrency: 2, OnConflict: conflictHash},
+			existing: []string{"IMG_0001.JPG", "IMG_0001_" + shortHash("item-1") + ".JPG"},
+			wantFiles: map[string]string{
+				"IMG_0001_" + shortHash("item-1") + ".JPG":   "existing",
+				"IMG_0001_" + shortHash("item-1") + "_1.JPG": "item-1",
+			},
+		},
+		{
+			name: "same filename within a batch with hash",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictHash},
+			setup: func(fake *fakePickerServer) {
+				fake.items[1].MediaFile.Filename = "IMG_0000.JPG"
+			},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG": "item-0",
+				"IMG_0000_" + shortHash("item-1") + ".JPG": "item-1",
+			},
+		},
+		{
+			name: "dot filenames fall back to the item ID",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictRename},
+			setup: func(fake *fakePickerServer) {
+				fake.items[1].MediaFile.Filename = ".."
+				fake.items[2].MediaFile.Filename = "."
+			},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG": "item-0",
+				"item-1.jpg":   "item-1",
+				"item-2.jpg":   "item-2",
+			},
+		},
+		{
+			name: "videos are downloaded with =dv",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictRename},
+			setup: func(fake *fakePickerServer) {
+				fake.addVideo(videoProcessingStatusReady)
+			},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG": "item-0",
+				"VID_0003.mp4": "item-3",
+			},
+		},
+		{
+			name: "videos still processing or failed are reported as failures",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictRename},
+			setup: func(fake *fakePickerServer) {
+				fake.addVideo(videoProcessingStatusProcessing)
+				fake.addVideo(videoProcessingStatusFailed)
+			},
+			wantFiles: map[string]string{
+				"IMG_0000.JPG": "item-0",
+			},
+			wantMissing: []string{"VID_0003.mp4", "VID_0004.mp4"},
+			wantErr:     "2 of 5 downloads failed",
+		},
+		{
+			name: "motion photos",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictRename, MotionPhotos: true},
+			setup: func(fake *fakePickerServer) {
+				fake.addMotionPhoto()
+			},
+			wantFiles: map[string]string{
+				"PXL_0003.MP.jpg": "item-3",
+				"PXL_0003.MP.mp4": "motion:item-3",
+				"IMG_0000.JPG":    "item-0",
+			},
+			// モーションフォトでない写真の動画部分はスキップされ、失敗にはならない
+			wantMissing: []string{"IMG_0000.mp4"},
+		},
+		{
+			name: "extension from the MIME type when the filename is missing",
+			opts: downloadOptions{Concurrency: 2, OnConflict: conflictRename},
+			setup: func(fake *fakePickerServer) {
+				fake.items[1].MediaFile.Filename = ""
+				fake.items[1].MediaFile.MimeType = "image/heic"
+				fake.addVideo(videoProcessingStatusReady)
+				fake.items[3].MediaFile.Filename = ""
+				fake.items[3].MediaFile.MimeType = "video/quicktime"
+			},
+			wantFiles: map[string]string{
+				"item-1.heic": "item-1",
+				"item-3.mov":  "item-3",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakePickerServer(t, 3)
+			outputDir := setupFakeEnv(t, fake, nil)
+			if tt.setup != nil {
+				tt.setup(fake)
+			}
+
+			if err := os.MkdirAll(outputDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.existing {
+				if err := os.WriteFile(filepath.Join(outputDir, name), []byte("existing"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			opts := tt.opts
+			opts.OutputDir = outputDir
+			err := runDownloadOnly(opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("runDownloadOnly = %v, want error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("runDownloadOnly: %v", err)
+			}
+
+			for name, want := range tt.wantFiles {
+				data, err := os.ReadFile(filepath.Join(outputDir, name))
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+					continue
+				}
+				if !bytes.HasPrefix(data, []byte(want)) {
+					t.Errorf("%s: got content %.20q, want prefix %q", name, data, want)
+				}
+			}
+			for _, name := range tt.wantMissing {
+				if _, err := os.Stat(filepath.Join(outputDir, name)); !os.IsNotExist(err) {
+					t.Errorf("%s should not be created", name)
+				}
+			}
+
+			// 失敗がある場合は --resume のためにマニフェストとセッションを残す
+			_, err = os.Stat(filepath.Join(outputDir, downloadManifestName))
+			if manifestKept := err == nil; manifestKept != (tt.wantErr != "") {
+				t.Errorf("manifest kept = %v, want %v", manifestKept, tt.wantErr != "")
+			}
+			wantDeleted := 1
+			if tt.wantErr != "" {
+				wantDeleted = 0
+			}
+			if len(fake.deletedSessions) != wantDeleted {
+				t.Errorf("deleted %d sessions, want %d", len(fake.deletedSessions), wantDeleted)
+			}
+		})
+	}
+}
+
+func TestRunDownloadOnlyResumeAfterFailure(t *testing.T) {
+	fake := newFakePickerServer(t, 3)
+	outputDir := setupFakeEnv(t, fake, nil)
+
+	fake.failingMedia["item-1"] = true
+	err := runDownloadOnly(downloadOptions{OutputDir: outputDir, Concurrency: 2, OnConflict: conflictRename})
+	if err == nil {
+		t.Fatal("runDownloadOnly should fail when an item fails")
+	}
+	if len(fake.deletedSessions) != 0 {
+		t.Fatalf("session should be kept for --resume, deleted %v", fake.deletedSessions)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "IMG_0001.JPG")); !os.IsNotExist(err) {
+		t.Fatalf("failed item should not leave a final file")
+	}
+
+	// 再開までに保存済みの baseUrl が失効していても、セッションから取得し直して続行する
+	fake.failingMedia["item-1"] = false
+	fake.expireBaseURLs()
+	if err := runDownloadOnly(downloadOptions{OutputDir: outputDir, Concurrency: 2, Resume: true}); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "IMG_0001.JPG"))
+	if err != nil || !bytes.Equal(data, fake.content["item-1"]) {
+		t.Errorf("resumed file has unexpected content (err=%v)", err)
+	}
+	if len(fake.deletedSessions) != 1 {
+		t.Errorf("session should be deleted after resume completes, deleted %v", fake.deletedSessions)
+	}
+}
+
+func TestRunDownloadOnlyResumeOverwritesStaleFile(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	outputDir := setupFakeEnv(t, fake, nil)
+
+	// overwrite で上書きする予定の既存ファイルは、再開時に完了済みとみなさない
+	stale := filepath.Join(outputDir, "IMG_0001.JPG")
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stale, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fake.failingMedia["item-1"] = true
+	if err := runDownloadOnly(downloadOptions{OutputDir: outputDir, Concurrency: 1, OnConflict: conflictOverwrite}); err == nil {
+		t.Fatal("runDownloadOnly should fail when an item fails")
+	}
+	manifest, err := loadDownloadManifest(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range manifest.Entries {
+		if want := entry.Item.ID == "item-0"; entry.Done != want {
+			t.Errorf("%s: done = %t, want %t", entry.Path, entry.Done, want)
+		}
+	}
+
+	fake.failingMedia["item-1"] = false
+	if err := runDownloadOnly(downloadOptions{OutputDir: outputDir, Concurrency: 1, Resume: true}); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	data, err := os.ReadFile(stale)
+	if err != nil || !bytes.Equal(data, fake.content["item-1"]) {
+		t.Errorf("resume should overwrite the stale file (err=%v, content=%.20q)", err, data)
+	}
+	if got := fake.mediaRequests["item-0=d"]; got != 1 {
+		t.Errorf("completed item-0 was fetched %d times, want 1", got)
+	}
+}
+
+func TestRunDownloadOnlyResumeAfterVideoProcessing(t *testing.T) {
+	fake := newFakePickerServer(t, 1)
+	outputDir := setupFakeEnv(t, fake, nil)
+
+	video := fake.addVideo(videoProcessingStatusProcessing)
+	if err := runDownloadOnly(downloadOptions{OutputDir: outputDir, Concurrency: 1, OnConflict: conflictRename}); err == nil {
+		t.Fatal("runDownloadOnly should fail while the video is still processing")
+	}
+
+	// 再開時には最新の処理状況を使う
+	video.MediaFile.MediaFileMetadata.VideoMetadata.ProcessingStatus = videoProcessingStatusReady
+	if err := runDownloadOnly(downloadOptions{OutputDir: outputDir, Concurrency: 1, Resume: true}); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, video.MediaFile.Filename))
+	if err != nil || !bytes.Equal(data, fake.content[video.ID]) {
+		t.Errorf("resumed video has unexpected content (err=%v)", err)
+	}
+}
+
+func TestRunDownloadOnlyRetriesInterruptedDownload(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	outputDir := setupFakeEnv(t, fake, nil)
+
+	fake.truncatedMedia["item-1"] = 1
+	if err := runDownloadOnly(downloadOptions{OutputDir: outputDir, Concurrency: 2, OnConflict: conflictRename}); err != nil {
+		t.Fatalf("runDownloadOnly: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "IMG_0001.JPG"))
+	if err != nil || !bytes.Equal(data, fake.content["item-1"]) {
+		t.Errorf("retried file has unexpected content (err=%v)", err)
+	}
+	if got := fake.mediaRequests["item-1=d"]; got != 2 {
+		t.Errorf("item-1 should be requested twice (truncated + ranged retry), got %d", got)
+	}
+	if got := fake.rangeRequests["item-1=d"]; got != 1 {
+		t.Errorf("retry should continue the .part file with a Range request, got %d ranged requests", got)
+	}
+	if len(fake.deletedSessions) != 1 {
+		t.Errorf("session should be deleted after a successful run, deleted %v", fake.deletedSessions)
+	}
+}
+
+func TestRunDownloadOnlyInterrupted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("os.Interrupt cannot be sent to a process on Windows")
+	}
+	fake := newFakePickerServer(t, 1)
+	outputDir := setupFakeEnv(t, fake, nil)
+
+	// ダウンロード中に Ctrl-C が押された場合を再現する
+	fake.beforeMedia = func(r *http.Request) {
+		process, _ := os.FindProcess(os.Getpid())
+		if err := process.Signal(os.Interrupt); err != nil {
+			t.Errorf("failed to interrupt the test process: %v", err)
+			return
+		}
+		<-r.Context().Done()
+	}
+
+	err := runDownloadOnly(downloadOptions{OutputDir: outputDir, Concurrency: 1, OnConflict: conflictRename})
+	if got := exitCode(err); got != exitCodeInterrupted {
+		t.Fatalf("exit code = %d (err=%v), want %d", got, err, exitCodeInterrupted)
+	}
+	if len(fake.deletedSessions) != 0 {
+		t.Errorf("session should be kept for --resume, deleted %v", fake.deletedSessions)
+	}
+}
+
+func TestDownloadImageToFileResumesPartialFile(t *testing.T) {
+	fake := newFakePickerServer(t, 1)
+	want := fake.content["item-0"]
+
+	outputPath := filepath.Join(t.TempDir(), "IMG_0000.JPG")
+	if err := os.WriteFile(outputPath+partialFileSuffix, want[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	url := getImageHighResURL(fake.items[0].MediaFile.BaseUrl)
+	if err := downloadImageToFile(context.Background(), fake.authClient(fake.accessToken), url, outputPath); err != nil {
+		t.Fatalf("downloadImageToFile: %v", err)
+	}
+
+	got, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("resumed download content mismatch: got %d bytes, want %d", len(got), len(want))
+	}
+	if _, err := os.Stat(outputPath + partialFileSuffix); !os.IsNotExist(err) {
+		t.Errorf("partial file should be renamed away")
+	}
+}
+
+func TestWaitForSelectionRetriesTransientErrors(t *testing.T) {
+	fake := newFakePickerServer(t, 1)
+	fake.transientErrors = 1
+
+	pickerClient := NewPickerClient(fake.authClient(fake.accessToken))
+	pickerClient.SetBaseURL(fake.URL + "/v1")
+
+	ctx := context.Background()
+	session, err := pickerClient.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	if err := pickerClient.WaitForSelection(ctx, session, io.Discard); err != nil {
+		t.Fatalf("WaitForSelection: %v", err)
+	}
+}
+
+func TestWaitForSelectionRetryStopsAtDeadline(t *testing.T) {
+	fake := newFakePickerServer(t, 1)
+	fake.pollsUntilSet = 1 << 20
+	fake.timeoutIn = "0.5s"
+
+	pickerClient := NewPickerClient(fake.authClient(fake.accessToken))
+	pickerClient.SetBaseURL(fake.URL + "/v1")
+
+	ctx := context.Background()
+	session, err := pickerClient.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// 期限が近づいてから一時的なエラーが続く
+	time.AfterFunc(200*time.Millisecond, func() {
+		fake.mu.Lock()
+		fake.transientErrors = maxPollRetries * 2
+		fake.mu.Unlock()
+	})
+
+	start := time.Now()
+	err = pickerClient.WaitForSelection(ctx, session, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "タイムアウト") {
+		t.Fatalf("WaitForSelection = %v, want a timeout error", err)
+	}
+	// バックオフ（1秒以上）を待たずに timeoutIn で終了する
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitForSelection took %v, want it to stop at the 0.5s deadline", elapsed)
+	}
+}
+
+func TestWaitForSelectionUsesCreatedSessionDeadline(t *testing.T) {
+	fake := newFakePickerServer(t, 1)
+	fake.pollsUntilSet = 1 << 20
+	fake.timeoutIn = "0.5s"
+
+	pickerClient := NewPickerClient(fake.authClient(fake.accessToken))
+	pickerClient.SetBaseURL(fake.URL + "/v1")
+
+	ctx := context.Background()
+	session, err := pickerClient.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// 最初のポーリングから一時的なエラーが続いても、作成時の timeoutIn で終了する
+	time.Sleep(200 * time.Millisecond)
+	fake.mu.Lock()
+	fake.transientErrors = maxPollRetries * 2
+	fake.mu.Unlock()
+
+	start := time.Now()
+	err = pickerClient.WaitForSelection(ctx, session, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "タイムアウト") {
+		t.Fatalf("WaitForSelection = %v, want a timeout error", err)
+	}
+	// 期限は作成時点から数えるので、呼び出しからは 0.5s より早く終わる
+	if elapsed := time.Since(start); elapsed > 450*time.Millisecond {
+		t.Errorf("WaitForSelection took %v, want it to stop at the session's remaining 0.3s", elapsed)
+	}
+}
+
+func TestAccessTokenRefresh(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	fake.accessToken = "expired-token"
+
+	setupFakeEnv(t, fake, &oauth2.Token{
+		AccessToken:  "expired-token",
+		RefreshToken: fake.refreshToken,
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(-time.Hour),
+	})
+
+	if err := runPicker(pickerOptions{}); err != nil {
+		t.Fatalf("runPicker: %v", err)
+	}
+
+	if fake.tokenRequests != 1 {
+		t.Errorf("token endpoint called %d times, want 1", fake.tokenRequests)
+	}
+
+	saved, err := loadToken()
+	if err != nil {
+		t.Fatalf("loadToken: %v", err)
+	}
+	if saved.AccessToken != fake.refreshedToken {
+		t.Errorf("saved access token = %q, want %q", saved.AccessToken, fake.refreshedToken)
+	}
+	if saved.RefreshToken != fake.refreshToken {
+		t.Errorf("refresh token should be preserved, got %q", saved.RefreshToken)
+	}
+}
+
+func TestAccessTokenRefreshDuringSelection(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	// ポーリング中（約1秒）に期限切れ（oauth2 は期限の10秒前から期限切れとみなす）になるトークン
+	fake.pollsUntilSet = 100
+
+	setupFakeEnv(t, fake, &oauth2.Token{
+		AccessToken:  fake.accessToken,
+		RefreshToken: fake.refreshToken,
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(10*time.Second + 300*time.Millisecond),
+	})
+
+	if err := runPicker(pickerOptions{}); err != nil {
+		t.Fatalf("runPicker: %v", err)
+	}
+
+	if fake.tokenRequests != 1 {
+		t.Errorf("token endpoint called %d times, want 1", fake.tokenRequests)
+	}
+	saved, err := loadToken()
+	if err != nil {
+		t.Fatalf("loadToken: %v", err)
+	}
+	if saved.AccessToken != fake.refreshedToken {
+		t.Errorf("refreshed token should be persisted, got %q", saved.AccessToken)
+	}
+}
+
+func TestRunPickerJSONKeepsStdoutClean(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	// 起動時にリフレッシュして保存するため、トークン関連のメッセージも出力される
+	setupFakeEnv(t, fake, &oauth2.Token{
+		AccessToken:  "expired-token",
+		RefreshToken: fake.refreshToken,
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(-time.Hour),
+	})
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = runPicker(pickerOptions{OutputFormat: outputFormatJSON})
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatalf("runPicker: %v", err)
+	}
+	out, _ := io.ReadAll(r)
+
+	// 標準出力には結果の JSON だけが書き出される
+	var items []MediaItem
+	if err := json.Unmarshal(out, &items); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, out)
+	}
+	if len(items) != 2 {
+		t.Errorf("%d items in the output, want 2", len(items))
+	}
+}
+
+func TestDeleteSessionRequiresAuthorization(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+
+	pickerClient := NewPickerClient(fake.authClient("wrong-token"))
+	pickerClient.SetBaseURL(fake.URL + "/v1")
+
+	err := pickerClient.DeleteSession(context.Background(), "session-1")
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("DeleteSession error = %v, want 401 APIError", err)
+	}
+}
+
+func TestSessionsDeleteKeepsTrackingOnTransientError(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+
+	pickerClient := NewPickerClient(fake.authClient(fake.accessToken))
+	pickerClient.SetBaseURL(fake.URL + "/v1")
+	session, err := pickerClient.CreateSession(context.Background())
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := trackSession(session, "picker"); err != nil {
+		t.Fatalf("trackSession: %v", err)
+	}
+
+	// 一時的なエラーで削除できなかったセッションは追跡対象に残す
+	fake.deleteErrors = 1
+	if err := runSessionsDelete(nil, true); err == nil {
+		t.Fatal("runSessionsDelete should report the failed deletion")
+	}
+	sessions, err := loadProfileSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("%d tracked sessions after a transient failure, want 1", len(sessions))
+	}
+
+	if err := runSessionsDelete(nil, true); err != nil {
+		t.Fatalf("runSessionsDelete retry: %v", err)
+	}
+	// 既に存在しないセッションは追跡対象から外すだけにする
+	if err := trackSession(session, "picker"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSessionsDelete(nil, true); err != nil {
+		t.Fatalf("runSessionsDelete of a missing session: %v", err)
+	}
+	if sessions, _ := loadProfileSessions(); len(sessions) != 0 {
+		t.Errorf("%d tracked sessions left, want 0", len(sessions))
+	}
+	if len(fake.deletedSessions) != 1 {
+		t.Errorf("deleted %d sessions, want 1", len(fake.deletedSessions))
+	}
+}
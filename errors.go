@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OAuth クライアントの設定がされていない
+var ErrNotConfigured = errors.New("Google OAuth credentials are not configured")
+
+// リフレッシュトークンが失効・取り消しされ、再ログインが必要
+var ErrTokenRevoked = errors.New("OAuth token has been revoked or has expired")
+
+// CLI の終了コード
+const (
+	exitCodeError         = 1
+	exitCodeNotConfigured = 3
+	exitCodeAuth          = 4
+	exitCodeAPI           = 5
+	exitCodeInterrupted   = 130
+)
+
+// Google API がエラーを返した場合のエラー
+// Code と Message は {"error": {"code": 404, "status": "NOT_FOUND", "message": "..."}} 形式のレスポンスから取得する
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("API error %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+	}
+	if e.Code == "" {
+		return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API error %d (%s): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// 再試行すれば成功する可能性のあるエラーか
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// 認証・認可のエラーか
+func (e *APIError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+
+	var googleErr struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &googleErr); err == nil {
+		apiErr.Code = googleErr.Error.Status
+		apiErr.Message = googleErr.Error.Message
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// トークンエンドポイントのエラーのうち、再ログインが必要なものを ErrTokenRevoked に変換する
+func wrapTokenError(err error) error {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+		return fmt.Errorf("%w: %v", ErrTokenRevoked, err)
+	}
+	return err
+}
+
+// エラーの種類に応じた終了コード
+func exitCode(err error) int {
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrNotConfigured):
+		return exitCodeNotConfigured
+	case errors.Is(err, ErrTokenRevoked):
+		return exitCodeAuth
+	case errors.As(err, &apiErr) && apiErr.Unauthorized():
+		return exitCodeAuth
+	case errors.As(err, &apiErr):
+		return exitCodeAPI
+	case errors.Is(err, context.Canceled):
+		return exitCodeInterrupted
+	default:
+		return exitCodeError
+	}
+}
+
+// エラーの種類に応じた対処方法
+func errorHint(err error) string {
+	var apiErr *APIError
+	switch {
+	case errors.Is(err, ErrNotConfigured):
+		return "Please run setup first: ./gphoto-cli setup"
+	case errors.Is(err, ErrTokenRevoked), errors.As(err, &apiErr) && apiErr.Unauthorized():
+		return "Please log in again: ./gphoto-cli auth login"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAPIErrorParsesGoogleErrorJSON(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+
+	pickerClient := NewPickerClient(fake.authClient(fake.accessToken))
+	pickerClient.SetBaseURL(fake.URL + "/v1")
+
+	_, err := pickerClient.GetSession(context.Background(), "missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("GetSession error = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "NOT_FOUND" || apiErr.Message != "session not found" {
+		t.Errorf("got status=%d code=%q message=%q", apiErr.StatusCode, apiErr.Code, apiErr.Message)
+	}
+	if got := exitCode(fmt.Errorf("wrapped: %w", err)); got != exitCodeAPI {
+		t.Errorf("exitCode = %d, want %d", got, exitCodeAPI)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "not configured", err: fmt.Errorf("failed to get Google config: %w", ErrNotConfigured), want: exitCodeNotConfigured},
+		{name: "token revoked", err: fmt.Errorf("failed to send request: %w", ErrTokenRevoked), want: exitCodeAuth},
+		{name: "unauthorized", err: &APIError{StatusCode: http.StatusUnauthorized}, want: exitCodeAuth},
+		{name: "server error", err: &APIError{StatusCode: http.StatusInternalServerError}, want: exitCodeAPI},
+		{name: "interrupted", err: fmt.Errorf("failed to wait for selection: %w", context.Canceled), want: exitCodeInterrupted},
+		{name: "other", err: errors.New("boom"), want: exitCodeError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRevokedRefreshTokenDuringRun(t *testing.T) {
+	fake := newFakePickerServer(t, 1)
+	setupFakeEnv(t, fake, nil)
+
+	expired := &oauth2.Token{
+		AccessToken:  "expired-token",
+		RefreshToken: "revoked-refresh-token",
+		Expiry:       time.Now().Add(-time.Hour),
+	}
+	config := fakeOAuthConfig(fake)
+	source := &persistingTokenSource{source: config.TokenSource(context.Background(), expired), out: io.Discard, last: expired}
+
+	pickerClient := NewPickerClient(oauth2.NewClient(context.Background(), source))
+	pickerClient.SetBaseURL(fake.URL + "/v1")
+
+	_, err := pickerClient.CreateSession(context.Background())
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("CreateSession error = %v, want ErrTokenRevoked", err)
+	}
+	if got := exitCode(err); got != exitCodeAuth {
+		t.Errorf("exitCode = %d, want %d", got, exitCodeAuth)
+	}
+	// クライアント ID とシークレットを残したまま再ログインできる
+	if got := errorHint(err); !strings.Contains(got, "auth login") {
+		t.Errorf("errorHint = %q, want auth login", got)
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// テスト用の Picker API / OAuth トークンエンドポイントのフェイク
+type fakePickerServer struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// 有効なアクセストークン（リフレッシュされると更新される）
+	accessToken  string
+	refreshToken string
+	// リフレッシュ時に発行するアクセストークン
+	refreshedToken string
+	tokenRequests  int
+	// 発行済みの認証コードと対応する PKCE code_challenge
+	authCodes map[string]string
+	// デバイス認証: 発行済みのデバイスコードと、承認されるまでに返す authorization_pending の回数
+	deviceCodes        map[string]int
+	devicePendingPolls int
+	// 取り消されたトークン
+	revokedTokens []string
+	// tokeninfo が返すスコープ（スペース区切り）
+	tokenScope string
+
+	items         []MediaItem
+	content       map[string][]byte
+	defaultPage   int
+	pollsUntilSet int
+	// mediaItems.list が常に同じ nextPageToken を返す（壊れたプロキシなどの再現）
+	repeatPageToken bool
+	// セッションの pollingConfig.timeoutIn（空の場合は 10s）
+	timeoutIn string
+	// GetSession の最初の N 回を一時的なエラー（503）にする
+	transientErrors int
+	// DeleteSession の最初の N 回を一時的なエラー（503）にする
+	deleteErrors int
+	// モーションフォトの動画部分（=dv で返す内容。写真IDごと）
+	motionVideos map[string][]byte
+	// ダウンロード時に 500 を返すメディアID
+	failingMedia map[string]bool
+	// 指定した回数だけ途中で接続を切るメディアID（残り回数）
+	truncatedMedia map[string]int
+	// baseUrl の世代（expireBaseURLs で更新され、古い baseUrl は 403 になる）
+	baseURLGeneration int
+	// メディアの取得回数（"ID=サイズ指定" ごと）
+	mediaRequests map[string]int
+	// Range 付きで取得された回数（"ID=サイズ指定" ごと）
+	rangeRequests map[string]int
+	// メディアを返す前に呼ばれる（中断などのテスト用）
+	beforeMedia func(r *http.Request)
+
+	sessions        map[string]*fakeSession
+	nextSessionID   int
+	deletedSessions []string
+	listedItems     int
+}
+
+type fakeSession struct {
+	polls   int
+	created time.Time
+}
+
+func newFakePickerServer(t *testing.T, itemCount int) *fakePickerServer {
+	t.Helper()
+
+	fake := &fakePickerServer{
+		accessToken:       "valid-token",
+		refreshToken:      "refresh-token",
+		refreshedToken:    "refreshed-token",
+		tokenScope:        "https://www.googleapis.com/auth/photospicker.mediaitems.readonly",
+		content:           map[string][]byte{},
+		defaultPage:       2,
+		pollsUntilSet:     2,
+		motionVideos:      map[string][]byte{},
+		failingMedia:      map[string]bool{},
+		truncatedMedia:    map[string]int{},
+		baseURLGeneration: 1,
+		mediaRequests:     map[string]int{},
+		rangeRequests:     map[string]int{},
+		authCodes:         map[string]string{},
+		deviceCodes:       map[string]int{},
+		sessions:          map[string]*fakeSession{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/sessions", fake.handleCreateSession)
+	mux.HandleFunc("GET /v1/sessions/{id}", fake.handleGetSession)
+	mux.HandleFunc("DELETE /v1/sessions/{id}", fake.handleDeleteSession)
+	mux.HandleFunc("GET /v1/mediaItems", fake.handleListMediaItems)
+	mux.HandleFunc("GET /media/{generation}/{file}", fake.handleMedia)
+	mux.HandleFunc("POST /token", fake.handleToken)
+	mux.HandleFunc("POST /device/code", fake.handleDeviceCode)
+	mux.HandleFunc("POST /revoke", fake.handleRevoke)
+	mux.HandleFunc("POST /tokeninfo", fake.handleTokenInfo)
+
+	fake.Server = httptest.NewServer(mux)
+	t.Cleanup(fake.Close)
+
+	// ダウンロードの再試行でテストが遅くならないようにする
+	retryDelay := downloadRetryDelay
+	downloadRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { downloadRetryDelay = retryDelay })
+
+	for i := 0; i < itemCount; i++ {
+		fake.addItem(mediaTypePhoto, "image/jpeg", fmt.Sprintf("IMG_%04d.JPG", i))
+	}
+
+	return fake
+}
+
+// 選択済みのアイテムを追加する（ID は item-N）
+func (f *fakePickerServer) addItem(mediaType, mimeType, filename string) *MediaItem {
+	i := len(f.items)
+	id := fmt.Sprintf("item-%d", i)
+	f.items = append(f.items, MediaItem{
+		ID:         id,
+		CreateTime: time.Date(2024, time.Month(i%12+1), 1, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+		Type:       mediaType,
+		MediaFile: MediaFile{
+			BaseUrl:  f.baseURL(id),
+			MimeType: mimeType,
+			Filename: filename,
+			MediaFileMetadata: MediaFileMetadata{
+				Width:       4000,
+				Height:      3000,
+				CameraMake:  "Google",
+				CameraModel: "Pixel 8",
+			},
+		},
+	})
+	f.content[id] = bytes.Repeat([]byte(id+";"), 512)
+	return &f.items[i]
+}
+
+// 動画を追加する（processingStatus が READY 以外の間はダウンロードできない）
+func (f *fakePickerServer) addVideo(processingStatus string) *MediaItem {
+	item := f.addItem(mediaTypeVideo, "video/mp4", fmt.Sprintf("VID_%04d.mp4", len(f.items)))
+	item.MediaFile.MediaFileMetadata.VideoMetadata = VideoMetadata{Fps: 30, ProcessingStatus: processingStatus}
+	return item
+}
+
+// モーションフォト（=dv で動画部分を取得できる写真）を追加する
+func (f *fakePickerServer) addMotionPhoto() *MediaItem {
+	item := f.addItem(mediaTypePhoto, "image/jpeg", fmt.Sprintf("PXL_%04d.MP.jpg", len(f.items)))
+	f.motionVideos[item.ID] = bytes.Repeat([]byte("motion:"+item.ID+";"), 256)
+	return item
+}
+
+// 指定したアクセストークンを Authorization ヘッダーに付ける HTTP クライアント
+func (f *fakePickerServer) authClient(accessToken string) *http.Client {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, f.Client())
+	return oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: accessToken}))
+}
+
+func (f *fakePickerServer) authorized(w http.ResponseWriter, r *http.Request) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+f.accessToken {
+		writeFakeError(w, http.StatusUnauthorized, "UNAUTHENTICATED", "invalid access token")
+		return false
+	}
+	return true
+}
+
+// 実際の API と同様に timeoutIn はセッション作成からの残り時間を返す
+func (f *fakePickerServer) sessionJSON(id string, session *fakeSession, mediaItemsSet bool) map[string]any {
+	timeout := 10 * time.Second
+	if f.timeoutIn != "" {
+		timeout, _ = time.ParseDuration(f.timeoutIn)
+	}
+	remaining := max(timeout-time.Since(session.created), 0)
+	return map[string]any{
+		"id":            id,
+		"pickerUri":     f.URL + "/pick/" + id,
+		"mediaItemsSet": mediaItemsSet,
+		"pollingConfig": map[string]string{
+			"pollInterval": "0.01s",
+			"timeoutIn":    fmt.Sprintf("%.3fs", remaining.Seconds()),
+		},
+		"expireTime": session.created.Add(time.Hour).UTC().Format(time.RFC3339),
+	}
+}
+
+func (f *fakePickerServer) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	f.mu.Lock()
+	f.nextSessionID++
+	id := fmt.Sprintf("session-%d", f.nextSessionID)
+	session := &fakeSession{created: time.Now()}
+	f.sessions[id] = session
+	body := f.sessionJSON(id, session, false)
+	f.mu.Unlock()
+
+	writeFakeJSON(w, body)
+}
+
+func (f *fakePickerServer) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	f.mu.Lock()
+	if f.transientErrors > 0 {
+		f.transientErrors--
+		f.mu.Unlock()
+		writeFakeError(w, http.StatusServiceUnavailable, "UNAVAILABLE", "try again")
+		return
+	}
+	session, ok := f.sessions[r.PathValue("id")]
+	if !ok {
+		f.mu.Unlock()
+		writeFakeError(w, http.StatusNotFound, "NOT_FOUND", "session not found")
+		return
+	}
+	session.polls++
+	body := f.sessionJSON(r.PathValue("id"), session, session.polls >= f.pollsUntilSet)
+	f.mu.Unlock()
+
+	writeFakeJSON(w, body)
+}
+
+func (f *fakePickerServer) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.deleteErrors > 0 {
+		f.deleteErrors--
+		writeFakeError(w, http.StatusServiceUnavailable, "UNAVAILABLE", "try again")
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := f.sessions[id]; !ok {
+		writeFakeError(w, http.StatusNotFound, "NOT_FOUND", "session not found")
+		return
+	}
+	delete(f.sessions, id)
+	f.deletedSessions = append(f.deletedSessions, id)
+	writeFakeJSON(w, map[string]any{})
+}
+
+func (f *fakePickerServer) handleListMediaItems(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.sessions[r.URL.Query().Get("sessionId")]; !ok {
+		writeFakeError(w, http.StatusNotFound, "NOT_FOUND", "session not found")
+		return
+	}
+
+	pageSize := f.defaultPage
+	if n, err := strconv.Atoi(r.URL.Query().Get("pageSize")); err == nil && n > 0 {
+		pageSize = n
+	}
+	offset := 0
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		offset, _ = strconv.Atoi(token)
+	}
+
+	end := min(offset+pageSize, len(f.items))
+	response := MediaItemsResponse{MediaItems: f.items[offset:end]}
+	if end < len(f.items) {
+		response.NextPageToken = strconv.Itoa(end)
+	}
+	if f.repeatPageToken {
+		response.NextPageToken = "repeated"
+	}
+	f.listedItems += end - offset
+
+	writeFakeJSON(w, response)
+}
+
+// baseUrl の内容を返す（=w-h はサムネイル）
+// 写真は =d でオリジナル、=dv でモーションフォトの動画部分（モーションフォトでなければ 404）
+// 動画は =dv でオリジナル、=d では静止画を返す
+func (f *fakePickerServer) handleMedia(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	if f.beforeMedia != nil {
+		f.beforeMedia(r)
+	}
+
+	id, suffix, ok := strings.Cut(r.PathValue("file"), "=")
+	if !ok {
+		http.Error(w, "missing size parameter", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	data, exists := f.content[id]
+	motionVideo, isMotionPhoto := f.motionVideos[id]
+	isVideo := slices.ContainsFunc(f.items, func(item MediaItem) bool {
+		return item.ID == id && item.Type == mediaTypeVideo
+	})
+	failing := f.failingMedia[id]
+	expired := r.PathValue("generation") != strconv.Itoa(f.baseURLGeneration)
+	truncated := f.truncatedMedia[id] > 0 && r.Header.Get("Range") == ""
+	if truncated {
+		f.truncatedMedia[id]--
+	}
+	f.mediaRequests[r.PathValue("file")]++
+	if r.Header.Get("Range") != "" {
+		f.rangeRequests[r.PathValue("file")]++
+	}
+	f.mu.Unlock()
+
+	switch {
+	case !exists:
+		http.NotFound(w, r)
+	case expired:
+		http.Error(w, "baseUrl has expired", http.StatusForbidden)
+	case failing:
+		http.Error(w, "backend error", http.StatusInternalServerError)
+	case truncated:
+		// Content-Length より短い内容で接続を切る
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data[:len(data)/2])
+	case suffix == "dv" && isVideo:
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, id, time.Time{}, bytes.NewReader(data))
+	case suffix == "dv" && isMotionPhoto:
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, id, time.Time{}, bytes.NewReader(motionVideo))
+	case suffix == "dv":
+		http.Error(w, "not a motion photo", http.StatusNotFound)
+	case suffix == "d" && isVideo:
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeThumbnail(id, suffix))
+	case suffix == "d":
+		http.ServeContent(w, r, id, time.Time{}, bytes.NewReader(data))
+	case strings.HasPrefix(suffix, "w"):
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeThumbnail(id, suffix))
+	default:
+		http.Error(w, "unknown size parameter", http.StatusBadRequest)
+	}
+}
+
+// baseUrl（世代ごとに異なる）
+func (f *fakePickerServer) baseURL(id string) string {
+	return fmt.Sprintf("%s/media/%d/%s", f.URL, f.baseURLGeneration, id)
+}
+
+// 発行済みの baseUrl を失効させ、以降の mediaItems.list では新しい baseUrl を返す
+func (f *fakePickerServer) expireBaseURLs() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.baseURLGeneration++
+	for i := range f.items {
+		f.items[i].MediaFile.BaseUrl = f.baseURL(f.items[i].ID)
+	}
+}
+
+func (f *fakePickerServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tokenRequests++
+	switch r.PostForm.Get("grant_type") {
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") != f.refreshToken {
+			writeFakeTokenError(w, "invalid_grant")
+			return
+		}
+	case "authorization_code":
+		// PKCE: code_verifier の S256 が認可リクエストの code_challenge と一致する必要がある
+		challenge, ok := f.authCodes[r.PostForm.Get("code")]
+		if !ok || oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) != challenge {
+			writeFakeTokenError(w, "invalid_grant")
+			return
+		}
+		delete(f.authCodes, r.PostForm.Get("code"))
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		pending, ok := f.deviceCodes[r.PostForm.Get("device_code")]
+		if !ok {
+			writeFakeTokenError(w, "invalid_grant")
+			return
+		}
+		if pending > 0 {
+			f.deviceCodes[r.PostForm.Get("device_code")] = pending - 1
+			writeFakeTokenError(w, "authorization_pending")
+			return
+		}
+		delete(f.deviceCodes, r.PostForm.Get("device_code"))
+	default:
+		writeFakeTokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	f.accessToken = f.refreshedToken
+	writeFakeJSON(w, map[string]any{
+		"access_token":  f.refreshedToken,
+		"refresh_token": f.refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+// Google と同じく verification_url というフィールド名で返す
+func (f *fakePickerServer) handleDeviceCode(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deviceCode := fmt.Sprintf("device-code-%d", len(f.deviceCodes)+1)
+	f.deviceCodes[deviceCode] = f.devicePendingPolls
+	writeFakeJSON(w, map[string]any{
+		"device_code":      deviceCode,
+		"user_code":        "ABCD-EFGH",
+		"verification_url": f.URL + "/device",
+		"expires_in":       1800,
+		"interval":         1,
+	})
+}
+
+// リフレッシュトークンを取り消すと、発行済みのアクセストークンも無効になる
+func (f *fakePickerServer) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token := r.PostForm.Get("token")
+	if token == "" || (token != f.refreshToken && token != f.accessToken) {
+		writeFakeTokenError(w, "invalid_token")
+		return
+	}
+	f.revokedTokens = append(f.revokedTokens, token)
+	f.refreshToken = ""
+	f.accessToken = ""
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakePickerServer) handleTokenInfo(w http.ResponseWriter, r *http.Request) {
+	// トークンは URL ではなくフォームで受け取る
+	if r.URL.Query().Has("access_token") {
+		http.Error(w, "access_token must not be sent in the URL", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if token := r.PostForm.Get("access_token"); token == "" || token != f.accessToken {
+		writeFakeTokenError(w, "invalid_token")
+		return
+	}
+	info := map[string]any{
+		"scope":      f.tokenScope,
+		"expires_in": "3599",
+	}
+	// 実際の tokeninfo と同様、email スコープがある場合のみメールアドレスを返す
+	if slices.Contains(strings.Fields(f.tokenScope), accountEmailScope) {
+		info["email"] = "user@example.com"
+	}
+	writeFakeJSON(w, info)
+}
+
+// 認可サーバーとして認証コードを発行（ブラウザでの同意の代わり）
+func (f *fakePickerServer) issueAuthCode(codeChallenge string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	code := fmt.Sprintf("auth-code-%d", len(f.authCodes)+1)
+	f.authCodes[code] = codeChallenge
+	return code
+}
+
+func writeFakeTokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func fakeThumbnail(id, suffix string) []byte {
+	return []byte("thumbnail:" + id + "=" + suffix)
+}
+
+func writeFakeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Google API と同じ形式のエラーレスポンス
+func writeFakeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    status,
+			"status":  code,
+			"message": message,
+		},
+	})
+}
+
+// HOME を一時ディレクトリに切り替え、フェイクサーバーを向いた設定とトークンを書き込む
+func setupFakeEnv(t *testing.T, fake *fakePickerServer, token *oauth2.Token) string {
+	t.Helper()
+
+	home := setTestHome(t)
+
+	config := getDefaultConfig()
+	config.GoogleClientID = "test-client-id"
+	config.GoogleClientSecret = "test-client-secret"
+	config.PickerAPIBaseURL = fake.URL + "/v1"
+	config.AuthURL = fake.URL + "/auth"
+	config.TokenURL = fake.URL + "/token"
+	config.RevokeURL = fake.URL + "/revoke"
+	config.TokenInfoURL = fake.URL + "/tokeninfo"
+	if err := saveConfig(config); err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+
+	if token == nil {
+		token = &oauth2.Token{
+			AccessToken:  fake.accessToken,
+			RefreshToken: fake.refreshToken,
+			TokenType:    "Bearer",
+			Expiry:       time.Now().Add(time.Hour),
+		}
+	}
+	store, err := activeCredentialStore()
+	if err != nil {
+		t.Fatalf("activeCredentialStore: %v", err)
+	}
+	data, _ := json.Marshal(token)
+	if err := store.Set(credentialKeyToken, data); err != nil {
+		t.Fatalf("write token: %v", err)
+	}
+
+	return filepath.Join(home, "downloads")
+}
+
+// HOME を一時ディレクトリに切り替え、XDG のディレクトリもその配下を使うようにする
+func setTestHome(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, name := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME", "GPHOTO_CONFIG_DIR"} {
+		t.Setenv(name, "")
+	}
+	return home
+}
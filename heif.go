@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// HEIF を変換できる外部コマンドが見つからない場合のエラー
+var errNoHEIFDecoder = errors.New("no HEIF decoder found (install heif-convert from libheif or ImageMagick, or set heif_decoder)")
+
+// HEIF（HEIC）を PNG に変換する外部コマンド
+// {input} と {output} はそれぞれ入力・出力ファイルのパスに置き換えられる
+type heifDecoder struct {
+	Name    string
+	Command string
+}
+
+// 自動検出する場合に試すコマンド（先頭ほど優先）
+// HEVC のデコードは Go だけでは実装が難しいため外部コマンドを使う
+var heifDecoders = []heifDecoder{
+	{Name: "heif-convert", Command: "heif-convert {input} {output}"},
+	{Name: "magick", Command: "magick {input} png:{output}"},
+	{Name: "sips", Command: "sips -s format png {input} --out {output}"},
+}
+
+// HEIF の ftyp ボックスのブランド
+var heifBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1"}
+
+func init() {
+	for _, brand := range heifBrands {
+		image.RegisterFormat("heif", "????ftyp"+brand, decodeHEIF, decodeHEIFConfig)
+	}
+}
+
+// image.Decode から呼ばれるデコーダーには設定を渡せないため、コマンドの開始時に設定する
+// 決定したデコーダーは次に設定するまで使い回す
+var heifDecoderState struct {
+	sync.Mutex
+	command  string
+	resolved bool
+	decoder  heifDecoder
+	err      error
+}
+
+// heif_decoder の設定（空の場合は PATH から自動検出）を反映する
+func setHEIFDecoderCommand(command string) {
+	heifDecoderState.Lock()
+	defer heifDecoderState.Unlock()
+	heifDecoderState.command = command
+	heifDecoderState.resolved = false
+}
+
+// 使用する HEIF デコーダー（初回のデコード時に決定する）
+func findHEIFDecoder() (heifDecoder, error) {
+	heifDecoderState.Lock()
+	defer heifDecoderState.Unlock()
+	if !heifDecoderState.resolved {
+		heifDecoderState.decoder, heifDecoderState.err = resolveHEIFDecoder(heifDecoderState.command)
+		heifDecoderState.resolved = true
+	}
+	return heifDecoderState.decoder, heifDecoderState.err
+}
+
+// heif_decoder の設定 > PATH にある既知のコマンド の順に決定
+func resolveHEIFDecoder(command string) (heifDecoder, error) {
+	if command != "" {
+		return heifDecoder{Name: "heif_decoder", Command: command}, nil
+	}
+
+	for _, decoder := range heifDecoders {
+		if _, err := exec.LookPath(decoder.Name); err == nil {
+			return decoder, nil
+		}
+	}
+	return heifDecoder{}, errNoHEIFDecoder
+}
+
+func validateHEIFDecoder(command string) error {
+	if !strings.Contains(command, "{input}") || !strings.Contains(command, "{output}") {
+		return fmt.Errorf("heif decoder command must contain {input} and {output}: %q", command)
+	}
+	return nil
+}
+
+// 外部コマンドで PNG に変換してからデコード
+// 変換後の画像には HEIF の回転・反転（irot / imir）が適用されている
+func decodeHEIF(r io.Reader) (image.Image, error) {
+	decoder, err := findHEIFDecoder()
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "gphoto-cli-heif-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	input := filepath.Join(tempDir, "input.heic")
+	output := filepath.Join(tempDir, "output.png")
+	file, err := os.Create(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	args := strings.Fields(decoder.Command)
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, "{input}", input)
+		args[i] = strings.ReplaceAll(arg, "{output}", output)
+	}
+	if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", decoder.Name, err, strings.TrimSpace(string(out)))
+	}
+
+	converted, err := os.Open(output)
+	if err != nil {
+		return nil, fmt.Errorf("%s did not write an image: %w", decoder.Name, err)
+	}
+	defer converted.Close()
+	return png.Decode(converted)
+}
+
+// 寸法だけを取得する手段がないため全体をデコードする
+func decodeHEIFConfig(r io.Reader) (image.Config, error) {
+	img, err := decodeHEIF(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: img.ColorModel(), Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+}
+
+// ISOBMFF のボックス
+type isoBox struct {
+	Type string
+	Data []byte
+}
+
+// data に含まれるボックスを順に読み取る
+func readISOBoxes(data []byte) ([]isoBox, error) {
+	var boxes []isoBox
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			// ファイルの最後まで
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated %s box", boxType)
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid %s box size %d", boxType, size)
+		}
+		boxes = append(boxes, isoBox{Type: boxType, Data: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+func findISOBox(boxes []isoBox, boxType string) (isoBox, bool) {
+	for _, box := range boxes {
+		if box.Type == boxType {
+			return box, true
+		}
+	}
+	return isoBox{}, false
+}
+
+// HEIF の Exif アイテムを取得（TIFF ヘッダーから始まるバイト列）
+// Exif がない場合は nil を返す
+func extractHEIFExif(data []byte) ([]byte, error) {
+	boxes, err := readISOBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := findISOBox(boxes, "meta")
+	if !ok || len(meta.Data) < 4 {
+		return nil, nil
+	}
+	// meta は FullBox（version と flags の4バイト）
+	children, err := readISOBoxes(meta.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	iinf, ok := findISOBox(children, "iinf")
+	if !ok {
+		return nil, nil
+	}
+	exifID, ok, err := findHEIFItemID(iinf.Data, "Exif")
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	iloc, ok := findISOBox(children, "iloc")
+	if !ok {
+		return nil, fmt.Errorf("HEIF has no iloc box")
+	}
+	item, err := readHEIFItem(iloc.Data, exifID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先頭4バイトは TIFF ヘッダーまでのオフセット（"Exif\0\0" など）
+	if len(item) < 4 {
+		return nil, fmt.Errorf("invalid Exif item")
+	}
+	offset := uint64(binary.BigEndian.Uint32(item)) + 4
+	if offset > uint64(len(item)) {
+		return nil, fmt.Errorf("invalid Exif item")
+	}
+	return item[offset:], nil
+}
+
+// iinf ボックスから指定した種類のアイテムの ID を探す
+func findHEIFItemID(iinf []byte, itemType string) (uint32, bool, error) {
+	r := &byteReader{data: iinf}
+	version := r.uint(1)
+	r.skip(3)
+	if version == 0 {
+		r.uint(2)
+	} else {
+		r.uint(4)
+	}
+	if r.err != nil {
+		return 0, false, fmt.Errorf("invalid iinf box")
+	}
+
+	entries, err := readISOBoxes(r.data[r.pos:])
+	if err != nil {
+		return 0, false, err
+	}
+	for _, entry := range entries {
+		if entry.Type != "infe" {
+			continue
+		}
+		// item_type があるのは version 2 以降
+		e := &byteReader{data: entry.Data}
+		version := e.uint(1)
+		e.skip(3)
+		if version < 2 {
+			continue
+		}
+		var id uint64
+		if version == 2 {
+			id = e.uint(2)
+		} else {
+			id = e.uint(4)
+		}
+		e.skip(2)
+		t := e.bytes(4)
+		if e.err != nil {
+			return 0, false, fmt.Errorf("invalid infe box")
+		}
+		if string(t) == itemType {
+			return uint32(id), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// iloc ボックスからアイテムのデータを取得（ファイル内のオフセットで指定されたもののみ）
+func readHEIFItem(iloc []byte, itemID uint32, file []byte) ([]byte, error) {
+	r := &byteReader{data: iloc}
+	version := r.uint(1)
+	r.skip(3)
+	sizes := r.uint(2)
+	offsetSize, lengthSize := int(sizes>>12), int(sizes>>8&0xf)
+	baseOffsetSize, indexSize := int(sizes>>4&0xf), int(sizes&0xf)
+	if version == 0 {
+		indexSize = 0
+	}
+	var itemCount uint64
+	if version < 2 {
+		itemCount = r.uint(2)
+	} else {
+		itemCount = r.uint(4)
+	}
+
+	for i := uint64(0); i < itemCount && r.err == nil; i++ {
+		var id uint64
+		if version < 2 {
+			id = r.uint(2)
+		} else {
+			id = r.uint(4)
+		}
+		constructionMethod := uint64(0)
+		if version == 1 || version == 2 {
+			constructionMethod = r.uint(2) & 0xf
+		}
+		r.skip(2)
+		baseOffset := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+
+		var item []byte
+		for j := uint64(0); j < extentCount && r.err == nil; j++ {
+			r.uint(indexSize)
+			offset := baseOffset + r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			if id != uint64(itemID) {
+				continue
+			}
+			if constructionMethod != 0 {
+				return nil, fmt.Errorf("unsupported iloc construction method %d", constructionMethod)
+			}
+			if length == 0 {
+				length = uint64(len(file)) - offset
+			}
+			if offset > uint64(len(file)) || length > uint64(len(file))-offset {
+				return nil, fmt.Errorf("item %d is out of range", itemID)
+			}
+			item = append(item, file[offset:offset+length]...)
+		}
+		if id == uint64(itemID) {
+			return item, r.err
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid iloc box")
+	}
+	return nil, fmt.Errorf("item %d not found in iloc", itemID)
+}
+
+// ビッグエンディアンの可変長整数を読む（範囲外の場合は err を設定）
+type byteReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if r.err != nil || r.pos+n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *byteReader) uint(n int) uint64 {
+	var v uint64
+	for _, b := range r.bytes(n) {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func (r *byteReader) skip(n int) {
+	r.bytes(n)
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +20,14 @@ var rootCmd = &cobra.Command{
 	Use:   "gphoto-cli",
 	Short: "Google Photos CLI Tool",
 	Long:  "A command-line interface tool for managing Google Photos using Google API",
+	// エラーは main で終了コードと共に表示する
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// カレントディレクトリの .env を環境変数として読み込む（既存の環境変数は上書きしない）
+		godotenv.Load()
+		return migrateLegacyDir()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("gphoto-cli - Google Photos CLI Tool")
 		fmt.Println("Use 'gphoto-cli --help' for more information")
@@ -36,10 +47,11 @@ var setupCmd = &cobra.Command{
 	Use:   "setup",
 	Short: "Interactive setup for Google OAuth credentials",
 	Long:  "Configure Google OAuth 2.0 credentials through an interactive setup process",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := runInteractiveSetup(); err != nil {
-			log.Fatalf("Setup failed: %v", err)
+			return fmt.Errorf("Setup failed: %w", err)
 		}
+		return nil
 	},
 }
 
@@ -52,223 +64,472 @@ var configCmd = &cobra.Command{
 var configShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show current configuration",
-	Run: func(cmd *cobra.Command, args []string) {
-		if err := runConfigShow(); err != nil {
-			log.Fatalf("Error showing config: %v", err)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		showSource, _ := cmd.Flags().GetBool("source")
+		if err := runConfigShow(showSource); err != nil {
+			return fmt.Errorf("Error showing config: %w", err)
 		}
+		return nil
 	},
 }
 
 var configResetCmd = &cobra.Command{
 	Use:   "reset",
 	Short: "Reset configuration and authentication",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := runConfigReset(); err != nil {
-			log.Fatalf("Error resetting config: %v", err)
+			return fmt.Errorf("Error resetting config: %w", err)
 		}
+		return nil
 	},
 }
 
 var downloadCmd = &cobra.Command{
 	Use:   "download",
 	Short: "Download selected photos to local directory",
-	Long:  "Select photos from Google Photos and download them to a specified directory",
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Select photos from Google Photos and download them to a specified directory.
+
+With --cache, downloaded files are also copied into the local cache (see 'cache stats')
+so that downloading the same items again does not refetch them. The copy uses additional
+disk space up to cache.max_size (default: 1GiB, env GPHOTO_CACHE_MAX_SIZE); least
+recently used entries are evicted beyond that and files larger than the limit are not cached.
+
+The picker session is deleted after the download. If some downloads fail or the run is
+interrupted with Ctrl-C, the session is kept instead so that --resume can refresh the
+expired download URLs; it is deleted once the resumed download completes.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// 設定確認
 		if !isConfigured() {
-			fmt.Println("❌ Google OAuth credentials are not configured.")
-			fmt.Println("Please run setup first: ./gphoto-cli setup")
-			os.Exit(1)
+			return ErrNotConfigured
 		}
 
-		outputDir, _ := cmd.Flags().GetString("output")
-		thumbnail, _ := cmd.Flags().GetBool("thumbnail")
-		
-		if err := runDownloadOnly(outputDir, thumbnail); err != nil {
-			log.Fatalf("Error downloading photos: %v", err)
+		opts, err := downloadOptionsFromFlags(cmd)
+		if err != nil {
+			return err
 		}
+		opts.Resume, _ = cmd.Flags().GetBool("resume")
+		if err := runDownloadOnly(opts); err != nil {
+			return fmt.Errorf("Error downloading photos: %w", err)
+		}
+		return nil
 	},
 }
 
 var pickerCmd = &cobra.Command{
 	Use:   "picker",
 	Short: "Use Google Photos Picker to select photos from your entire library",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// 設定確認
 		if !isConfigured() {
-			fmt.Println("❌ Google OAuth credentials are not configured.")
-			fmt.Println("Please run setup first: ./gphoto-cli setup")
-			os.Exit(1)
+			return ErrNotConfigured
+		}
+
+		// 出力形式は フラグ > 環境変数 > 設定ファイル の優先順
+		config, _, err := loadLayeredConfig(cmd)
+		if err != nil {
+			return err
 		}
 
-		if err := runPicker(); err != nil {
-			log.Fatalf("Error running picker: %v", err)
+		pageSize, _ := cmd.Flags().GetInt("page-size")
+		keepSession, _ := cmd.Flags().GetBool("keep-session")
+
+		opts := pickerOptions{
+			PageSize:     pageSize,
+			KeepSession:  keepSession,
+			OutputFormat: config.OutputFormat,
+		}
+		if err := runPicker(opts); err != nil {
+			return fmt.Errorf("Error running picker: %w", err)
 		}
+		return nil
 	},
 }
 
 
-func runPicker() error {
-	config, err := getGoogleConfig()
-	if err != nil {
-		return fmt.Errorf("failed to get Google config: %v", err)
+// picker コマンドのオプション
+type pickerOptions struct {
+	PageSize     int
+	KeepSession  bool
+	OutputFormat string
+}
+
+// Ctrl-C / SIGTERM でキャンセルされるコンテキストを作成
+// 最初のシグナルで横取りをやめ、後片付け中にもう一度 Ctrl-C されたら既定の動作どおり即座に終了する
+func notifyInterruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+func runPicker(opts pickerOptions) error {
+	if opts.OutputFormat == "" {
+		opts.OutputFormat = outputFormatText
+	}
+	if err := validateOutputFormat(opts.OutputFormat); err != nil {
+		return err
+	}
+
+	// 構造化出力の場合、標準出力には結果だけを書き出し、進捗メッセージは標準エラーに回す
+	var progress io.Writer = os.Stdout
+	if isStructuredOutput(opts.OutputFormat) {
+		progress = os.Stderr
 	}
 
-	accessToken, err := getAccessToken(config)
+	config, err := getGoogleConfig()
 	if err != nil {
-		return fmt.Errorf("failed to get access token: %v", err)
+		return fmt.Errorf("failed to get Google config: %w", err)
 	}
 
-	client := &http.Client{}
-	pickerClient := NewPickerClient(client, accessToken)
-	
-	ctx := context.Background()
-	
-	// セッションを作成
-	fmt.Println("Google Photos Picker セッションを作成中...")
-	session, err := pickerClient.CreateSession(ctx)
+	// トークンは実行中に期限が切れても自動でリフレッシュされる
+	client, err := getClient(context.Background(), config, progress)
 	if err != nil {
-		return fmt.Errorf("failed to create picker session: %v", err)
+		return fmt.Errorf("failed to get access token: %w", err)
 	}
 
-	fmt.Printf("Google Photos Picker を開いてください:\n%s\n\n", session.PickerUri)
-	fmt.Println("ブラウザで上記URLを開き、写真を選択してください...")
+	pickerClient := NewPickerClient(client)
+	pickerClient.SetBaseURL(getPickerAPIBaseURL())
+	pickerClient.SetPageSize(opts.PageSize)
 	
-	// 選択完了を待機
-	if err := pickerClient.WaitForSelection(ctx, session.Name); err != nil {
-		return fmt.Errorf("failed to wait for selection: %v", err)
+	// Ctrl-C でも後片付け（セッション削除）が行われるようにする
+	ctx, stop := notifyInterruptContext()
+	defer stop()
+	
+	session, mediaItems, err := selectMediaItems(ctx, pickerClient, "", "picker", progress)
+	if session != nil {
+		if opts.KeepSession {
+			fmt.Fprintf(progress, "ℹ️  セッションを保持しています: %s\n", sessionIDFromName(session.Name))
+		} else {
+			defer deletePickerSession(pickerClient, session.Name, progress)
+		}
 	}
-
-	// 選択された写真を取得
-	fmt.Println("選択された写真を取得中...")
-	mediaItems, err := pickerClient.ListMediaItems(ctx, session.Name)
 	if err != nil {
-		return fmt.Errorf("failed to list selected media items: %v", err)
+		return err
 	}
 
 	// 結果を表示
-	if len(mediaItems) == 0 {
-		fmt.Println("選択された写真がありません。")
-		return nil
+	if err := writeMediaItems(os.Stdout, opts.OutputFormat, mediaItems); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
 	}
 
-	fmt.Printf("選択された写真 (%d件):\n\n", len(mediaItems))
-	for i, item := range mediaItems {
-		fmt.Printf("%d. %s\n", i+1, item.MediaFile.Filename)
-		fmt.Printf("   ID: %s\n", item.ID)
-		fmt.Printf("   Type: %s (%s)\n", item.Type, item.MediaFile.MimeType)
-		fmt.Printf("   作成日時: %s\n", item.CreateTime)
-		fmt.Printf("   サイズ: %dx%d\n", item.MediaFile.MediaFileMetadata.Width, item.MediaFile.MediaFileMetadata.Height)
-		if item.MediaFile.MediaFileMetadata.CameraMake != "" {
-			fmt.Printf("   カメラ: %s %s\n", item.MediaFile.MediaFileMetadata.CameraMake, item.MediaFile.MediaFileMetadata.CameraModel)
-		}
-		if item.MediaFile.MediaFileMetadata.PhotoMetadata.FocalLength > 0 {
-			fmt.Printf("   撮影設定: f/%.1f, %dmm, ISO%d, %s\n", 
-				item.MediaFile.MediaFileMetadata.PhotoMetadata.ApertureFNumber,
-				int(item.MediaFile.MediaFileMetadata.PhotoMetadata.FocalLength),
-				item.MediaFile.MediaFileMetadata.PhotoMetadata.IsoEquivalent,
-				item.MediaFile.MediaFileMetadata.PhotoMetadata.ExposureTime)
-		}
+	return nil
+}
 
-		fmt.Printf("   URL: %s\n", item.MediaFile.BaseUrl)
-		fmt.Println()
-	}
+// download コマンドのオプション
+type downloadOptions struct {
+	OutputDir    string
+	Thumbnail    bool
+	PageSize     int
+	Concurrency  int
+	Resume       bool
+	OnConflict   string
+	NameTemplate string
+	MotionPhotos bool
+	// 写真を変換して保存する場合の形式（jpeg / png / webp）と品質
+	Convert string
+	Quality int
+	// HEIF の変換に使う外部コマンド（空の場合は自動検出）
+	HEIFDecoder string
+	// キャッシュから復元し、ダウンロードしたファイルをキャッシュにも保存する
+	UseCache bool
+	// 既存の Picker セッションから取得する場合のセッション名
+	SessionName string
+	KeepSession bool
+}
 
-	return nil
+// download 系コマンド共通のフラグを登録
+func addDownloadFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", "", "Output directory for downloaded images (default: ~/gphoto-downloads)")
+	cmd.Flags().Bool("thumbnail", false, "Download thumbnail size instead of full resolution")
+	cmd.Flags().IntP("concurrency", "c", 4, "Number of parallel downloads")
+	cmd.Flags().String("on-conflict", conflictRename, "What to do when the output file already exists: skip, overwrite, rename or hash")
+	cmd.Flags().String("name-template", "", `Output path template relative to the output directory (e.g. '{{.CreateTime | date "2006/01"}}/{{.CameraModel}}/{{.Filename}}')`)
+	cmd.Flags().Bool("motion-photos", false, "Also download the video part of motion photos as .mp4")
+	cmd.Flags().String("convert", "", "Convert photos to jpeg, png or webp after downloading (Exif is kept)")
+	cmd.Flags().Int("quality", 90, "Quality (1-100) for --convert jpeg and webp")
+	cmd.Flags().Bool("cache", false, "Reuse the local cache and also copy downloaded files into it (uses extra disk space up to cache.max_size)")
+	cmd.Flags().Bool("keep-session", false, "Keep the picker session after downloading so it can be resumed with 'sessions resume'")
+	cmd.Flags().Int("page-size", 0, "Number of media items fetched per API page (max 100, default: API default)")
 }
 
-func runDownloadOnly(outputDir string, thumbnail bool) error {
-	config, err := getGoogleConfig()
-	if err != nil {
-		return fmt.Errorf("failed to get Google config: %v", err)
+// 設定ファイルのパスの先頭の ~ をホームディレクトリに展開
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
 	}
-
-	accessToken, err := getAccessToken(config)
+	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Errorf("failed to get access token: %v", err)
+		return path
 	}
+	return filepath.Join(homeDir, path[1:])
+}
 
-	client := &http.Client{}
-	pickerClient := NewPickerClient(client, accessToken)
-	
-	ctx := context.Background()
-	
-	// セッションを作成
-	fmt.Println("Google Photos Picker セッションを作成中...")
-	session, err := pickerClient.CreateSession(ctx)
+// download 系コマンド共通のフラグを読み取る
+// 出力先・並列数・衝突ポリシー・テンプレートは フラグ > 環境変数 > 設定ファイル > デフォルト の優先順
+func downloadOptionsFromFlags(cmd *cobra.Command) (downloadOptions, error) {
+	config, _, err := loadLayeredConfig(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to create picker session: %v", err)
+		return downloadOptions{}, err
 	}
 
-	fmt.Printf("Google Photos Picker を開いてください:\n%s\n\n", session.PickerUri)
-	fmt.Println("ブラウザで上記URLを開き、写真を選択してください...")
-	
-	// 選択完了を待機
-	if err := pickerClient.WaitForSelection(ctx, session.Name); err != nil {
-		return fmt.Errorf("failed to wait for selection: %v", err)
+	thumbnail, _ := cmd.Flags().GetBool("thumbnail")
+	pageSize, _ := cmd.Flags().GetInt("page-size")
+	motionPhotos, _ := cmd.Flags().GetBool("motion-photos")
+	keepSession, _ := cmd.Flags().GetBool("keep-session")
+	useCache, _ := cmd.Flags().GetBool("cache")
+
+	return downloadOptions{
+		OutputDir:    expandHome(config.Download.OutputDir),
+		Thumbnail:    thumbnail,
+		PageSize:     pageSize,
+		Concurrency:  config.Download.Concurrency,
+		OnConflict:   config.Download.OnConflict,
+		NameTemplate: config.Download.NameTemplate,
+		MotionPhotos: motionPhotos,
+		Convert:      config.Download.Convert,
+		Quality:      config.Download.Quality,
+		HEIFDecoder:  config.HEIFDecoder,
+		UseCache:     useCache,
+		KeepSession:  keepSession,
+	}, nil
+}
+
+func runDownloadOnly(opts downloadOptions) error {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
 	}
+	setHEIFDecoderCommand(opts.HEIFDecoder)
 
-	// 選択された写真を取得
-	fmt.Println("選択された写真を取得中...")
-	mediaItems, err := pickerClient.ListMediaItems(ctx, session.Name)
+	config, err := getGoogleConfig()
 	if err != nil {
-		return fmt.Errorf("failed to list selected media items: %v", err)
+		return fmt.Errorf("failed to get Google config: %w", err)
 	}
 
-	// 結果を表示
-	if len(mediaItems) == 0 {
-		fmt.Println("選択された写真がありません。")
-		return nil
+	// トークンは実行中に期限が切れても自動でリフレッシュされる
+	client, err := getClient(context.Background(), config, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
 	}
 
+	pickerClient := NewPickerClient(client)
+	pickerClient.SetBaseURL(getPickerAPIBaseURL())
+	pickerClient.SetPageSize(opts.PageSize)
+	
+	// Ctrl-C でも後片付け（セッション削除）が行われるようにする
+	ctx, stop := notifyInterruptContext()
+	defer stop()
+
+	// 完了後に削除するセッション
+	// ダウンロードに失敗・中断した場合は --resume で再開できるよう保持する
+	sessionName := ""
+	keepSession := opts.KeepSession
+	defer func() {
+		switch {
+		case sessionName == "":
+		case !keepSession:
+			deletePickerSession(pickerClient, sessionName, os.Stdout)
+		case !opts.KeepSession:
+			fmt.Printf("ℹ️  再開用にセッションを保持しています: %s\n", sessionIDFromName(sessionName))
+		}
+	}()
+
 	// 出力ディレクトリの設定
+	outputDir := opts.OutputDir
 	if outputDir == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			return fmt.Errorf("failed to get home directory: %v", err)
+			return fmt.Errorf("failed to get home directory: %w", err)
 		}
 		outputDir = filepath.Join(homeDir, "gphoto-downloads")
 	}
+
+	var manifest *downloadManifest
+	var mediaItems []MediaItem
+	if opts.Resume {
+		// 前回の中断したダウンロードを再開
+		manifest, err = loadDownloadManifest(outputDir)
+		if err != nil {
+			return fmt.Errorf("failed to load download manifest: %w", err)
+		}
+		fmt.Printf("🔁 前回のダウンロードを再開します (%s 作成)\n", manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		sessionName = manifest.SessionName
+		if sessionName != "" {
+			if err := refreshManifestItems(ctx, pickerClient, manifest); err != nil {
+				fmt.Printf("Warning: failed to refresh download URLs from the picker session (saved URLs may have expired): %v\n", err)
+			}
+		}
+		for _, entry := range manifest.Entries {
+			mediaItems = append(mediaItems, entry.Item)
+		}
+		opts.Thumbnail = manifest.Thumbnail
+		opts.Convert = manifest.Convert
+		opts.Quality = manifest.Quality
+	} else {
+		// ファイル名テンプレートと衝突ポリシーを先に検証
+		planner, err := newDownloadPlanner(outputDir, opts.NameTemplate, opts.OnConflict, opts.Thumbnail, opts.Convert)
+		if err != nil {
+			return err
+		}
+
+		session, items, err := selectMediaItems(ctx, pickerClient, opts.SessionName, "download", os.Stdout)
+		if session != nil {
+			sessionName = session.Name
+			if opts.KeepSession {
+				fmt.Printf("ℹ️  セッションを保持しています: %s\n", sessionIDFromName(session.Name))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		mediaItems = items
+
+		// 保存先を決定
+		manifest = &downloadManifest{
+			CreatedAt:   time.Now(),
+			Thumbnail:   opts.Thumbnail,
+			Convert:     opts.Convert,
+			Quality:     opts.Quality,
+			SessionName: sessionName,
+		}
+		for _, item := range mediaItems {
+			relPath, skip, err := planner.plan(item)
+			if err != nil {
+				return fmt.Errorf("failed to determine output path for %s: %w", item.MediaFile.Filename, err)
+			}
+			if skip {
+				fmt.Printf("⏭️  既に存在するためスキップ: %s\n", relPath)
+				continue
+			}
+			manifest.Entries = append(manifest.Entries, downloadManifestEntry{Item: item, Path: relPath})
+
+			// モーションフォトの動画部分は写真と同じ名前の .mp4 として保存
+			if opts.MotionPhotos && !opts.Thumbnail && item.Type == mediaTypePhoto {
+				manifest.Entries = append(manifest.Entries, downloadManifestEntry{
+					Item:        item,
+					Path:        planner.planSidecar(relPath, ".mp4"),
+					MotionVideo: true,
+				})
+			}
+		}
+	}
+
+	// 結果を表示
+	if len(mediaItems) == 0 {
+		fmt.Println("選択された写真がありません。")
+		return nil
+	}
 	
 	// 出力ディレクトリを作成
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// 中断時に --resume で再開できるよう選択内容を保存
+	if !opts.Resume {
+		if err := saveDownloadManifest(outputDir, manifest); err != nil {
+			fmt.Printf("Warning: failed to save download manifest: %v\n", err)
+		}
 	}
 
 	fmt.Printf("📂 ダウンロード先: %s\n", outputDir)
-	fmt.Printf("選択された写真 (%d件) をダウンロード中...\n\n", len(mediaItems))
-
-	for i, item := range mediaItems {
-		fmt.Printf("%d/%d: %s\n", i+1, len(mediaItems), item.MediaFile.Filename)
-		
-		// URLを適切に調整
-		imageUrl := item.MediaFile.BaseUrl
-		if thumbnail {
-			imageUrl = getImageThumbnailURL(imageUrl, 800, 600)
-		} else {
-			imageUrl = getImageHighResURL(imageUrl)
+	fmt.Printf("選択された写真 (%d件) をダウンロード中... (同時実行数: %d)\n\n", len(manifest.Entries), opts.Concurrency)
+
+	// --cache の場合、以前に取得したファイルはキャッシュから復元する
+	var cache *mediaCache
+	if opts.UseCache {
+		if cache, err = openMediaCache(); err != nil {
+			fmt.Printf("Warning: cache is disabled: %v\n", err)
+		}
+	}
+
+	jobs := make([]downloadJob, 0, len(manifest.Entries))
+	// ジョブに対応するマニフェストのエントリ
+	jobEntries := make([]int, 0, len(manifest.Entries))
+	skipped := 0
+	for i, entry := range manifest.Entries {
+		item := entry.Item
+
+		// URLを適切に調整（動画は =dv、モーションフォトの動画部分も =dv）
+		mediaUrl := getMediaDownloadURL(item, opts.Thumbnail)
+		if entry.MotionVideo {
+			mediaUrl = getVideoDownloadURL(item.MediaFile.BaseUrl)
 		}
 
-		// ファイル名を決定（元のファイル名を使用）
-		filename := item.MediaFile.Filename
-		if filename == "" {
-			// ファイル名が空の場合はIDを使用
-			ext := ".jpg" // デフォルト
-			if strings.Contains(item.MediaFile.MimeType, "heif") {
-				ext = ".heic"
+		outputPath := filepath.Join(outputDir, entry.Path)
+
+		// 再開時は完了済みのファイルをスキップし、それ以外は前回の途中ファイルを破棄
+		if opts.Resume {
+			if entry.Done {
+				skipped++
+				continue
 			}
-			filename = item.ID + ext
+		} else {
+			os.Remove(outputPath + partialFileSuffix)
+			os.Remove(outputPath + convertSourceSuffix + partialFileSuffix)
 		}
-		
-		outputPath := filepath.Join(outputDir, filename)
 
-		// 画像をダウンロード
-		if err := downloadImageToFile(client, accessToken, imageUrl, outputPath); err != nil {
-			fmt.Printf("   ❌ Error: %v\n", err)
-			continue
+		// テンプレートでサブディレクトリが指定されている場合に備えて作成
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
 		}
 
-		fmt.Printf("   ✅ ダウンロード完了: %s\n", outputPath)
+		jobs = append(jobs, downloadJob{
+			Index:       len(jobs),
+			Item:        item,
+			URL:         mediaUrl,
+			OutputPath:  outputPath,
+			MotionVideo: entry.MotionVideo,
+			Convert:     opts.Convert,
+			Quality:     opts.Quality,
+			Cache:       cache,
+		})
+		jobEntries = append(jobEntries, i)
+	}
+
+	// 完了したエントリを記録し、中断しても --resume で取得し直さないようにする
+	markDone := func(job downloadJob) {
+		manifest.Entries[jobEntries[job.Index]].Done = true
+		if err := saveDownloadManifest(outputDir, manifest); err != nil {
+			fmt.Printf("Warning: failed to save download manifest: %v\n", err)
+		}
+	}
+
+	if skipped > 0 {
+		fmt.Printf("⏭️  ダウンロード済みの %d 件をスキップします\n\n", skipped)
+	}
+
+	// 画像を並列にダウンロード（進捗は選択順に表示）
+	results := downloadAll(ctx, client, jobs, opts.Concurrency, func(result downloadResult) {
+		fmt.Printf("%d/%d: %s\n", result.Job.Index+1, len(jobs), filepath.Base(result.Job.OutputPath))
+		if errors.Is(result.Err, errNotMotionPhoto) {
+			markDone(result.Job)
+			fmt.Println("   ⏭️  モーションフォトではないためスキップ")
+			return
+		}
+		if result.Err != nil {
+			fmt.Printf("   ❌ Error: %v\n", result.Err)
+			return
+		}
+		markDone(result.Job)
+		fmt.Printf("   ✅ ダウンロード完了: %s\n", result.Job.OutputPath)
+	})
+
+	failed := printDownloadSummary(results)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// Ctrl-C で中断した場合も --resume で続きから再開できるようにする
+		keepSession = true
+		fmt.Println("\n💡 中断したダウンロードは --resume で再開できます")
+		return fmt.Errorf("download interrupted: %w", ctx.Err())
+	}
+	if failed > 0 {
+		// 再開時に baseUrl を取得し直せるようセッションは削除しない
+		keepSession = true
+		fmt.Println("\n💡 失敗した写真は --resume で再ダウンロードできます")
+		return fmt.Errorf("%d of %d downloads failed", failed, len(results))
+	}
+
+	// すべて完了したのでマニフェストは不要
+	if err := removeDownloadManifest(outputDir); err != nil {
+		fmt.Printf("Warning: failed to remove download manifest: %v\n", err)
 	}
 
 	fmt.Printf("\n🎉 すべてのダウンロードが完了しました！\n")
@@ -278,72 +539,50 @@ func runDownloadOnly(outputDir string, thumbnail bool) error {
 }
 
 // ヘルパー関数
+// baseUrl はそのままでは取得できず、サイズやダウンロード指定のパラメータを付ける必要がある
 func getImageThumbnailURL(baseUrl string, width, height int) string {
-	if strings.Contains(baseUrl, "googleusercontent.com") {
-		return fmt.Sprintf("%s=w%d-h%d", baseUrl, width, height)
-	}
-	return baseUrl
+	return fmt.Sprintf("%s=w%d-h%d", baseUrl, width, height)
 }
 
 func getImageHighResURL(baseUrl string) string {
-	if strings.Contains(baseUrl, "googleusercontent.com") {
-		return baseUrl + "=d"
-	}
-	return baseUrl
+	return baseUrl + "=d"
 }
 
-func downloadImageToFile(client *http.Client, accessToken, imageUrl, outputPath string) error {
-	// ディレクトリの存在と権限を確認
-	dir := filepath.Dir(outputPath)
-	if stat, err := os.Stat(dir); err != nil {
-		return fmt.Errorf("directory not accessible: %v", err)
-	} else if !stat.IsDir() {
-		return fmt.Errorf("path is not a directory: %s", dir)
-	}
-
-	// 画像をダウンロード
-	req, err := http.NewRequest("GET", imageUrl, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-
-	// 認証ヘッダーを追加
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to download image: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download image: status %d", resp.StatusCode)
-	}
-
-	// ファイルに保存
-	file, err := os.Create(outputPath)
-	if err != nil {
-		// より詳細なエラー情報を提供
-		if os.IsPermission(err) {
-			return fmt.Errorf("permission denied: cannot create file %s (check directory permissions)", outputPath)
-		}
-		return fmt.Errorf("failed to create file: %v", err)
-	}
-	defer file.Close()
+func getVideoDownloadURL(baseUrl string) string {
+	return baseUrl + "=dv"
+}
 
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to save image: %v", err)
+// メディアの種類に応じたダウンロードURL
+// 動画のサムネイルは静止画（JPEG）として取得される
+func getMediaDownloadURL(item MediaItem, thumbnail bool) string {
+	switch {
+	case thumbnail:
+		return getImageThumbnailURL(item.MediaFile.BaseUrl, 800, 600)
+	case item.Type == mediaTypeVideo:
+		return getVideoDownloadURL(item.MediaFile.BaseUrl)
+	default:
+		return getImageHighResURL(item.MediaFile.BaseUrl)
 	}
-
-	return nil
 }
 
 func init() {
-	downloadCmd.Flags().StringP("output", "o", "", "Output directory for downloaded images (default: ~/gphoto-downloads)")
-	downloadCmd.Flags().Bool("thumbnail", false, "Download thumbnail size instead of full resolution")
+	// API エンドポイントの上書き（ローカルのフェイクサーバーでのテスト用）
+	rootCmd.PersistentFlags().StringVar(&flagPickerAPIBaseURL, "picker-api-url", "", "Override the Picker API base URL (env: GPHOTO_PICKER_API_URL)")
+	rootCmd.PersistentFlags().StringVar(&flagAuthURL, "auth-url", "", "Override the OAuth authorization endpoint (env: GPHOTO_AUTH_URL)")
+	rootCmd.PersistentFlags().StringVar(&flagTokenURL, "token-url", "", "Override the OAuth token endpoint (env: GPHOTO_TOKEN_URL)")
+	rootCmd.PersistentFlags().StringVar(&flagDeviceAuthURL, "device-auth-url", "", "Override the OAuth device authorization endpoint (env: GPHOTO_DEVICE_AUTH_URL)")
+	rootCmd.PersistentFlags().StringVar(&flagRevokeURL, "revoke-url", "", "Override the OAuth token revocation endpoint (env: GPHOTO_REVOKE_URL)")
+	rootCmd.PersistentFlags().StringVar(&flagTokenInfoURL, "tokeninfo-url", "", "Override the OAuth token info endpoint (env: GPHOTO_TOKENINFO_URL)")
+
+	addDownloadFlags(downloadCmd)
+	downloadCmd.Flags().Bool("resume", false, "Resume an interrupted download batch in the output directory without picking again")
+
+	pickerCmd.Flags().Int("page-size", 0, "Number of media items fetched per API page (max 100, default: API default)")
+	pickerCmd.Flags().StringP("output-format", "o", outputFormatText, "Output format: text, json, ndjson, csv or yaml")
+	pickerCmd.Flags().Bool("keep-session", false, "Keep the picker session so its selection can be downloaded later with 'sessions resume'")
 
 	// config サブコマンドの設定
+	configShowCmd.Flags().Bool("source", false, "Show every setting with where its value comes from (default, file, env or flag)")
 	configCmd.AddCommand(configShowCmd)
 	configCmd.AddCommand(configResetCmd)
 
@@ -356,7 +595,10 @@ func init() {
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		if hint := errorHint(err); hint != "" {
+			fmt.Fprintln(os.Stderr, hint)
+		}
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// 同名ファイルが存在する場合の扱い
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictRename    = "rename"
+	conflictHash      = "hash"
+)
+
+// --name-template で参照できるフィールド
+type nameTemplateData struct {
+	ID          string
+	Type        string
+	CreateTime  time.Time
+	Filename    string
+	Basename    string
+	Ext         string
+	MimeType    string
+	Width       int
+	Height      int
+	CameraMake  string
+	CameraModel string
+}
+
+// --name-template で使える関数
+var nameTemplateFuncs = template.FuncMap{
+	"date": func(layout string, t time.Time) string {
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.Local().Format(layout)
+	},
+	"default": func(def, value string) string {
+		if strings.TrimSpace(value) == "" {
+			return def
+		}
+		return value
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// 出力ファイルのパスを決定する
+type downloadPlanner struct {
+	outputDir  string
+	template   *template.Template
+	onConflict string
+	thumbnail  bool
+	// 変換して保存する場合の形式（写真の拡張子を変える）
+	convert  string
+	reserved map[string]bool
+}
+
+func validateConflictPolicy(policy string) error {
+	switch policy {
+	case conflictSkip, conflictOverwrite, conflictRename, conflictHash:
+		return nil
+	default:
+		return fmt.Errorf("invalid conflict policy %q (expected skip, overwrite, rename or hash)", policy)
+	}
+}
+
+func newDownloadPlanner(outputDir, nameTemplate, onConflict string, thumbnail bool, convert string) (*downloadPlanner, error) {
+	if err := validateConflictPolicy(onConflict); err != nil {
+		return nil, err
+	}
+	if convert != "" {
+		if err := validateConvertFormat(convert); err != nil {
+			return nil, err
+		}
+	}
+
+	planner := &downloadPlanner{
+		outputDir:  outputDir,
+		onConflict: onConflict,
+		thumbnail:  thumbnail,
+		convert:    convert,
+		reserved:   map[string]bool{},
+	}
+
+	if nameTemplate != "" {
+		tmpl, err := template.New("name").Funcs(nameTemplateFuncs).Option("missingkey=error").Parse(nameTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name template: %w", err)
+		}
+		planner.template = tmpl
+	}
+
+	return planner, nil
+}
+
+// メディアアイテムの保存先（outputDir からの相対パス）を決定する
+// 衝突ポリシーが skip でディスク上に既にファイルがある場合は skip=true を返す
+func (p *downloadPlanner) plan(item MediaItem) (relPath string, skip bool, err error) {
+	relPath, err = p.render(item)
+	if err != nil {
+		return "", false, err
+	}
+
+	if p.isTaken(relPath) {
+		switch p.onConflict {
+		case conflictSkip, conflictOverwrite:
+			// 同じバッチ内の別アイテム同士はスキップ・上書きせずリネームする
+			if p.reserved[relPath] {
+				relPath = p.nextFreeName(relPath)
+			} else if p.onConflict == conflictSkip {
+				return relPath, true, nil
+			}
+		case conflictRename:
+			relPath = p.nextFreeName(relPath)
+		case conflictHash:
+			relPath = withNameSuffix(relPath, "_"+shortHash(item.ID))
+			if p.isTaken(relPath) {
+				relPath = p.nextFreeName(relPath)
+			}
+		}
+	}
+
+	p.reserved[relPath] = true
+	return relPath, false, nil
+}
+
+// 本体と同じ名前で拡張子だけ異なる付随ファイルの保存先を決定する
+// 付随ファイルは常にリネームで衝突を回避する
+func (p *downloadPlanner) planSidecar(relPath, ext string) string {
+	sidecar := strings.TrimSuffix(relPath, filepath.Ext(relPath)) + ext
+	if p.isTaken(sidecar) {
+		sidecar = p.nextFreeName(sidecar)
+	}
+	p.reserved[sidecar] = true
+	return sidecar
+}
+
+// テンプレートからファイル名を生成
+func (p *downloadPlanner) render(item MediaItem) (string, error) {
+	filename := defaultFilename(item)
+	if p.thumbnail && item.Type == mediaTypeVideo {
+		// 動画のサムネイルは静止画として保存される
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".jpg"
+	}
+	if p.convert != "" && item.Type == mediaTypePhoto {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + convertExtensions[p.convert]
+	}
+	relPath := filename
+	if p.template != nil {
+		var buf bytes.Buffer
+		if err := p.template.Execute(&buf, newNameTemplateData(item, filename)); err != nil {
+			return "", fmt.Errorf("failed to render name template: %w", err)
+		}
+		relPath = filepath.Clean(filepath.FromSlash(strings.TrimSpace(buf.String())))
+	}
+
+	// テンプレートの有無にかかわらず、出力ディレクトリの外に書き込まないよう検証
+	if relPath == "." || !filepath.IsLocal(relPath) {
+		return "", fmt.Errorf("invalid output path for %s: %q", item.ID, relPath)
+	}
+
+	return relPath, nil
+}
+
+// 同じバッチ内で予約済み、またはディスク上に既に存在するか
+func (p *downloadPlanner) isTaken(relPath string) bool {
+	if p.reserved[relPath] {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(p.outputDir, relPath))
+	return err == nil
+}
+
+// name_1.jpg, name_2.jpg ... のように空いている名前を探す
+func (p *downloadPlanner) nextFreeName(relPath string) string {
+	for n := 1; ; n++ {
+		candidate := withNameSuffix(relPath, fmt.Sprintf("_%d", n))
+		if !p.isTaken(candidate) {
+			return candidate
+		}
+	}
+}
+
+func newNameTemplateData(item MediaItem, filename string) nameTemplateData {
+	createTime, _ := time.Parse(time.RFC3339, item.CreateTime)
+	ext := filepath.Ext(filename)
+	metadata := item.MediaFile.MediaFileMetadata
+
+	return nameTemplateData{
+		ID:          item.ID,
+		Type:        item.Type,
+		CreateTime:  createTime,
+		Filename:    filename,
+		Basename:    strings.TrimSuffix(filename, ext),
+		Ext:         ext,
+		MimeType:    item.MediaFile.MimeType,
+		Width:       metadata.Width,
+		Height:      metadata.Height,
+		CameraMake:  sanitizePathComponent(metadata.CameraMake),
+		CameraModel: sanitizePathComponent(metadata.CameraModel),
+	}
+}
+
+// 元のファイル名（空の場合はIDから生成）
+func defaultFilename(item MediaItem) string {
+	filename := sanitizePathComponent(item.MediaFile.Filename)
+	if filename == "" || filename == "." || filename == ".." {
+		// ファイル名が空の場合はIDとMIMEタイプから生成
+		filename = item.ID + extensionForMimeType(item.MediaFile.MimeType, item.Type)
+	}
+	return filename
+}
+
+// よく使われるMIMEタイプの拡張子（mime パッケージの結果はOSによって異なるため優先）
+var mimeTypeExtensions = map[string]string{
+	"image/jpeg":        ".jpg",
+	"image/png":         ".png",
+	"image/gif":         ".gif",
+	"image/webp":        ".webp",
+	"image/heic":        ".heic",
+	"image/heif":        ".heic",
+	"image/avif":        ".avif",
+	"image/bmp":         ".bmp",
+	"image/tiff":        ".tif",
+	"image/x-adobe-dng": ".dng",
+	"video/mp4":         ".mp4",
+	"video/quicktime":   ".mov",
+	"video/3gpp":        ".3gp",
+	"video/webm":        ".webm",
+	"video/x-matroska":  ".mkv",
+	"video/x-msvideo":   ".avi",
+	"video/mpeg":        ".mpg",
+	"video/mp2t":        ".mts",
+}
+
+// MIMEタイプからファイル拡張子を決定
+func extensionForMimeType(mimeType, mediaType string) string {
+	mediaMime, _, err := mime.ParseMediaType(mimeType)
+	if err == nil {
+		if ext, ok := mimeTypeExtensions[mediaMime]; ok {
+			return ext
+		}
+		if exts, err := mime.ExtensionsByType(mediaMime); err == nil && len(exts) > 0 {
+			return exts[0]
+		}
+	}
+
+	// 不明な場合はメディアの種類から推測
+	if mediaType == mediaTypeVideo || strings.HasPrefix(mimeType, "video/") {
+		return ".mp4"
+	}
+	return ".jpg"
+}
+
+// パス区切り文字などファイル名に使えない文字を置き換える
+func sanitizePathComponent(s string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, s))
+}
+
+func withNameSuffix(relPath, suffix string) string {
+	ext := filepath.Ext(relPath)
+	return strings.TrimSuffix(relPath, ext) + suffix + ext
+}
+
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:4])
+}
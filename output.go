@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// picker コマンドの出力形式
+const (
+	outputFormatText   = "text"
+	outputFormatJSON   = "json"
+	outputFormatNDJSON = "ndjson"
+	outputFormatCSV    = "csv"
+	outputFormatYAML   = "yaml"
+)
+
+// CSV 出力の列
+var mediaItemCSVHeader = []string{
+	"id", "createTime", "type", "filename", "mimeType", "baseUrl",
+	"width", "height", "cameraMake", "cameraModel",
+	"focalLength", "apertureFNumber", "isoEquivalent", "exposureTime",
+	"fps", "processingStatus",
+}
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case outputFormatText, outputFormatJSON, outputFormatNDJSON, outputFormatCSV, outputFormatYAML:
+		return nil
+	default:
+		return fmt.Errorf("invalid output format %q (expected text, json, ndjson, csv or yaml)", format)
+	}
+}
+
+// text 以外はスクリプトから扱う形式か
+func isStructuredOutput(format string) bool {
+	return format != "" && format != outputFormatText
+}
+
+// 選択されたメディアアイテムを指定の形式で書き出す
+func writeMediaItems(w io.Writer, format string, mediaItems []MediaItem) error {
+	// 空の選択でも JSON/YAML は空配列を出力する
+	if mediaItems == nil {
+		mediaItems = []MediaItem{}
+	}
+
+	switch format {
+	case "", outputFormatText:
+		writeMediaItemsText(w, mediaItems)
+		return nil
+	case outputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(mediaItems)
+	case outputFormatNDJSON:
+		encoder := json.NewEncoder(w)
+		for _, item := range mediaItems {
+			if err := encoder.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case outputFormatCSV:
+		return writeMediaItemsCSV(w, mediaItems)
+	case outputFormatYAML:
+		encoder := yaml.NewEncoder(w)
+		defer encoder.Close()
+		return encoder.Encode(mediaItems)
+	default:
+		return validateOutputFormat(format)
+	}
+}
+
+func writeMediaItemsText(w io.Writer, mediaItems []MediaItem) {
+	if len(mediaItems) == 0 {
+		fmt.Fprintln(w, "選択された写真がありません。")
+		return
+	}
+
+	fmt.Fprintf(w, "選択された写真 (%d件):\n\n", len(mediaItems))
+	for i, item := range mediaItems {
+		writeMediaItemText(w, i, item)
+		fmt.Fprintln(w)
+	}
+}
+
+// 1件分のメタデータを表示（view コマンドでも使用）
+func writeMediaItemText(w io.Writer, index int, item MediaItem) {
+	metadata := item.MediaFile.MediaFileMetadata
+	fmt.Fprintf(w, "%d. %s\n", index+1, item.MediaFile.Filename)
+	fmt.Fprintf(w, "   ID: %s\n", item.ID)
+	fmt.Fprintf(w, "   Type: %s (%s)\n", item.Type, item.MediaFile.MimeType)
+	fmt.Fprintf(w, "   作成日時: %s\n", item.CreateTime)
+	fmt.Fprintf(w, "   サイズ: %dx%d\n", metadata.Width, metadata.Height)
+	if metadata.CameraMake != "" {
+		fmt.Fprintf(w, "   カメラ: %s %s\n", metadata.CameraMake, metadata.CameraModel)
+	}
+	if metadata.PhotoMetadata.FocalLength > 0 {
+		fmt.Fprintf(w, "   撮影設定: f/%.1f, %dmm, ISO%d, %s\n",
+			metadata.PhotoMetadata.ApertureFNumber,
+			int(metadata.PhotoMetadata.FocalLength),
+			metadata.PhotoMetadata.IsoEquivalent,
+			metadata.PhotoMetadata.ExposureTime)
+	}
+	if item.Type == mediaTypeVideo {
+		fmt.Fprintf(w, "   動画: %.2f fps, 処理状態: %s\n", metadata.VideoMetadata.Fps, metadata.VideoMetadata.ProcessingStatus)
+	}
+
+	fmt.Fprintf(w, "   URL: %s\n", item.MediaFile.BaseUrl)
+}
+
+func writeMediaItemsCSV(w io.Writer, mediaItems []MediaItem) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(mediaItemCSVHeader); err != nil {
+		return err
+	}
+
+	for _, item := range mediaItems {
+		metadata := item.MediaFile.MediaFileMetadata
+		record := []string{
+			item.ID,
+			item.CreateTime,
+			item.Type,
+			item.MediaFile.Filename,
+			item.MediaFile.MimeType,
+			item.MediaFile.BaseUrl,
+			strconv.Itoa(metadata.Width),
+			strconv.Itoa(metadata.Height),
+			metadata.CameraMake,
+			metadata.CameraModel,
+			formatCSVFloat(metadata.PhotoMetadata.FocalLength),
+			formatCSVFloat(metadata.PhotoMetadata.ApertureFNumber),
+			formatCSVInt(metadata.PhotoMetadata.IsoEquivalent),
+			metadata.PhotoMetadata.ExposureTime,
+			formatCSVFloat(metadata.VideoMetadata.Fps),
+			metadata.VideoMetadata.ProcessingStatus,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// 値がない（0）場合は空欄にする
+func formatCSVFloat(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatCSVInt(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func testMediaItems() []MediaItem {
+	return []MediaItem{
+		{
+			ID:         "photo-1",
+			CreateTime: "2024-05-01T10:00:00Z",
+			Type:       mediaTypePhoto,
+			MediaFile: MediaFile{
+				BaseUrl:  "https://lh3.googleusercontent.com/photo-1",
+				MimeType: "image/jpeg",
+				Filename: "IMG_0001.JPG",
+				MediaFileMetadata: MediaFileMetadata{
+					Width:       4000,
+					Height:      3000,
+					CameraMake:  "Google",
+					CameraModel: "Pixel 8",
+					PhotoMetadata: PhotoMetadata{
+						FocalLength:     6.9,
+						ApertureFNumber: 1.7,
+						IsoEquivalent:   100,
+						ExposureTime:    "0.01s",
+					},
+				},
+			},
+		},
+		{
+			ID:   "video-1",
+			Type: mediaTypeVideo,
+			MediaFile: MediaFile{
+				MimeType: "video/mp4",
+				Filename: "VID_0001.MP4",
+				MediaFileMetadata: MediaFileMetadata{
+					VideoMetadata: VideoMetadata{Fps: 30, ProcessingStatus: videoProcessingStatusReady},
+				},
+			},
+		},
+	}
+}
+
+func TestWriteMediaItems(t *testing.T) {
+	items := testMediaItems()
+
+	tests := []struct {
+		format string
+		check  func(t *testing.T, out string)
+	}{
+		{
+			format: outputFormatJSON,
+			check: func(t *testing.T, out string) {
+				var got []MediaItem
+				if err := json.Unmarshal([]byte(out), &got); err != nil {
+					t.Fatalf("invalid JSON: %v", err)
+				}
+				if len(got) != 2 || got[0].MediaFile.MediaFileMetadata.PhotoMetadata.IsoEquivalent != 100 {
+					t.Errorf("unexpected JSON round trip: %+v", got)
+				}
+			},
+		},
+		{
+			format: outputFormatNDJSON,
+			check: func(t *testing.T, out string) {
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				if len(lines) != 2 {
+					t.Fatalf("got %d lines, want 2", len(lines))
+				}
+				var got MediaItem
+				if err := json.Unmarshal([]byte(lines[1]), &got); err != nil || got.MediaFile.MediaFileMetadata.VideoMetadata.Fps != 30 {
+					t.Errorf("unexpected NDJSON line %q (err=%v)", lines[1], err)
+				}
+			},
+		},
+		{
+			format: outputFormatCSV,
+			check: func(t *testing.T, out string) {
+				records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+				if err != nil {
+					t.Fatalf("invalid CSV: %v", err)
+				}
+				if len(records) != 3 || len(records[1]) != len(mediaItemCSVHeader) {
+					t.Fatalf("unexpected CSV shape: %v", records)
+				}
+				if records[1][9] != "Pixel 8" || records[2][14] != "30" {
+					t.Errorf("unexpected CSV values: %v", records)
+				}
+			},
+		},
+		{
+			format: outputFormatYAML,
+			check: func(t *testing.T, out string) {
+				var got []MediaItem
+				if err := yaml.Unmarshal([]byte(out), &got); err != nil {
+					t.Fatalf("invalid YAML: %v", err)
+				}
+				if len(got) != 2 || got[0].MediaFile.MediaFileMetadata.CameraModel != "Pixel 8" {
+					t.Errorf("unexpected YAML round trip: %+v", got)
+				}
+			},
+		},
+		{
+			format: outputFormatText,
+			check: func(t *testing.T, out string) {
+				if !strings.Contains(out, "IMG_0001.JPG") || !strings.Contains(out, "30.00 fps") {
+					t.Errorf("unexpected text output:\n%s", out)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeMediaItems(&buf, tt.format, items); err != nil {
+				t.Fatalf("writeMediaItems: %v", err)
+			}
+			tt.check(t, buf.String())
+		})
+	}
+}
+
+func TestWriteMediaItemsEmptySelection(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeMediaItems(&buf, outputFormatJSON, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("empty selection should be an empty JSON array, got %q", buf.String())
+	}
+
+	if err := validateOutputFormat("xml"); err == nil {
+		t.Error("validateOutputFormat should reject unknown formats")
+	}
+}
@@ -4,105 +4,198 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// mediaItems.list の pageSize の上限（API仕様）
+const maxMediaItemsPageSize = 100
+
+// MediaItem.Type の値
+const (
+	mediaTypePhoto = "PHOTO"
+	mediaTypeVideo = "VIDEO"
+)
+
+// VideoMetadata.ProcessingStatus の値
+const (
+	videoProcessingStatusProcessing = "PROCESSING"
+	videoProcessingStatusReady      = "READY"
+	videoProcessingStatusFailed     = "FAILED"
+)
+
+// 選択待ちのポーリング設定（APIが返さない場合のデフォルト）
+const (
+	defaultPollInterval     = 2 * time.Second
+	defaultSelectionTimeout = 10 * time.Minute
+	// 一時的なエラー（5xx/429）を連続で再試行する上限
+	maxPollRetries    = 5
+	maxRetryBackoff   = 30 * time.Second
+	initialRetryDelay = 1 * time.Second
+)
+
 type PickerSession struct {
-	Name            string `json:"name"`
-	PickerUri       string `json:"pickerUri"`
-	MediaItemsSet   bool   `json:"mediaItemsSet"`
-	ID              string `json:"id"`
+	Name            string        `json:"name"`
+	PickerUri       string        `json:"pickerUri"`
+	MediaItemsSet   bool          `json:"mediaItemsSet"`
+	ID              string        `json:"id"`
+	PollingConfig   PollingConfig `json:"pollingConfig"`
+	ExpireTime      string        `json:"expireTime"`
+
+	// レスポンスを受け取った時刻（timeoutIn の起点）
+	fetchedAt time.Time
+}
+
+// サーバーが推奨するポーリング間隔とタイムアウト（"5s" のような Duration 文字列）
+type PollingConfig struct {
+	PollInterval string `json:"pollInterval"`
+	TimeoutIn    string `json:"timeoutIn"`
+}
+
+// ポーリング間隔（未指定や不正な値の場合はデフォルト）
+func (s *PickerSession) pollInterval() time.Duration {
+	if d, err := time.ParseDuration(s.PollingConfig.PollInterval); err == nil && d > 0 {
+		return d
+	}
+	return defaultPollInterval
+}
+
+// 選択を待つ期限（timeoutIn はレスポンス取得時点からの残り時間。expireTime の方が早ければそちら）
+// サーバーがどちらも返さない場合はデフォルトを使い、false を返す
+func (s *PickerSession) deadline() (time.Time, bool) {
+	now := s.fetchedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+	deadline := now.Add(defaultSelectionTimeout)
+	known := false
+	if d, err := time.ParseDuration(s.PollingConfig.TimeoutIn); err == nil && d >= 0 {
+		deadline = now.Add(d)
+		known = true
+	}
+	if expire, err := time.Parse(time.RFC3339, s.ExpireTime); err == nil && expire.Before(deadline) {
+		deadline = expire
+		known = true
+	}
+	return deadline, known
 }
 
 type MediaFile struct {
-	BaseUrl             string                 `json:"baseUrl"`
-	MimeType            string                 `json:"mimeType"`
-	Filename            string                 `json:"filename"`
-	MediaFileMetadata   MediaFileMetadata      `json:"mediaFileMetadata"`
+	BaseUrl             string                 `json:"baseUrl" yaml:"baseUrl"`
+	MimeType            string                 `json:"mimeType" yaml:"mimeType"`
+	Filename            string                 `json:"filename" yaml:"filename"`
+	MediaFileMetadata   MediaFileMetadata      `json:"mediaFileMetadata" yaml:"mediaFileMetadata"`
 }
 
 type MediaFileMetadata struct {
-	Width       int           `json:"width"`
-	Height      int           `json:"height"`
-	CameraMake  string        `json:"cameraMake"`
-	CameraModel string        `json:"cameraModel"`
-	PhotoMetadata PhotoMetadata `json:"photoMetadata"`
+	Width       int           `json:"width" yaml:"width"`
+	Height      int           `json:"height" yaml:"height"`
+	CameraMake  string        `json:"cameraMake" yaml:"cameraMake"`
+	CameraModel string        `json:"cameraModel" yaml:"cameraModel"`
+	PhotoMetadata PhotoMetadata `json:"photoMetadata" yaml:"photoMetadata"`
+	VideoMetadata VideoMetadata `json:"videoMetadata" yaml:"videoMetadata"`
 }
 
 type PhotoMetadata struct {
-	FocalLength     float64 `json:"focalLength"`
-	ApertureFNumber float64 `json:"apertureFNumber"`
-	IsoEquivalent   int     `json:"isoEquivalent"`
-	ExposureTime    string  `json:"exposureTime"`
+	FocalLength     float64 `json:"focalLength" yaml:"focalLength"`
+	ApertureFNumber float64 `json:"apertureFNumber" yaml:"apertureFNumber"`
+	IsoEquivalent   int     `json:"isoEquivalent" yaml:"isoEquivalent"`
+	ExposureTime    string  `json:"exposureTime" yaml:"exposureTime"`
+}
+
+type VideoMetadata struct {
+	Fps              float64 `json:"fps" yaml:"fps"`
+	ProcessingStatus string  `json:"processingStatus" yaml:"processingStatus"`
 }
 
 type MediaItem struct {
-	ID          string    `json:"id"`
-	CreateTime  string    `json:"createTime"`
-	Type        string    `json:"type"`
-	MediaFile   MediaFile `json:"mediaFile"`
+	ID          string    `json:"id" yaml:"id"`
+	CreateTime  string    `json:"createTime" yaml:"createTime"`
+	Type        string    `json:"type" yaml:"type"`
+	MediaFile   MediaFile `json:"mediaFile" yaml:"mediaFile"`
 }
 
 type MediaItemsResponse struct {
-	MediaItems []MediaItem `json:"mediaItems"`
+	MediaItems    []MediaItem `json:"mediaItems"`
+	NextPageToken string      `json:"nextPageToken"`
 }
 
+// httpClient は認証済みのクライアント（getClient で作成）を想定し、リクエストには Authorization ヘッダーを付けない
 type PickerClient struct {
-	httpClient  *http.Client
-	accessToken string
+	httpClient *http.Client
+	baseURL    string
+	pageSize   int
 }
 
-func NewPickerClient(httpClient *http.Client, accessToken string) *PickerClient {
+func NewPickerClient(httpClient *http.Client) *PickerClient {
 	return &PickerClient{
-		httpClient:  httpClient,
-		accessToken: accessToken,
+		httpClient: httpClient,
+		baseURL:    defaultPickerAPIBaseURL,
+	}
+}
+
+// Picker API のベースURLを設定（ローカルのフェイクサーバーなどに向ける場合）
+func (pc *PickerClient) SetBaseURL(baseURL string) {
+	if baseURL == "" {
+		baseURL = defaultPickerAPIBaseURL
 	}
+	pc.baseURL = strings.TrimSuffix(baseURL, "/")
+}
+
+// mediaItems.list の1ページあたりの件数を設定（0以下はAPIのデフォルト）
+func (pc *PickerClient) SetPageSize(pageSize int) {
+	if pageSize > maxMediaItemsPageSize {
+		pageSize = maxMediaItemsPageSize
+	}
+	pc.pageSize = pageSize
 }
 
 func (pc *PickerClient) CreateSession(ctx context.Context) (*PickerSession, error) {
-	url := "https://photospicker.googleapis.com/v1/sessions"
+	url := pc.baseURL + "/sessions"
 	
 	// セッション作成リクエスト（空のオブジェクト）
 	reqBody := map[string]interface{}{}
 	
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 	
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	
-	req.Header.Set("Authorization", "Bearer "+pc.accessToken)
 	req.Header.Set("Content-Type", "application/json")
 	
 	resp, err := pc.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp)
 	}
 	
 	// レスポンス全体を読み取ってデバッグ
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 	
 	var session PickerSession
 	if err := json.Unmarshal(body, &session); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
+	session.fetchedAt = time.Now()
 	
 	// セッション名が空の場合はIDを使用
 	if session.Name == "" && session.ID != "" {
@@ -113,94 +206,230 @@ func (pc *PickerClient) CreateSession(ctx context.Context) (*PickerSession, erro
 }
 
 func (pc *PickerClient) GetSession(ctx context.Context, sessionName string) (*PickerSession, error) {
-	url := fmt.Sprintf("https://photospicker.googleapis.com/v1/%s", sessionName)
+	url := fmt.Sprintf("%s/%s", pc.baseURL, sessionNameFromID(sessionName))
 	
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	
-	req.Header.Set("Authorization", "Bearer "+pc.accessToken)
-	
 	resp, err := pc.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp)
 	}
 	
 	var session PickerSession
 	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	session.fetchedAt = time.Now()
+	
+	// セッション名が空の場合はIDを使用
+	if session.Name == "" && session.ID != "" {
+		session.Name = sessionNameFromID(session.ID)
 	}
 	
 	return &session, nil
 }
 
+// セッションを削除（選択されたメディアアイテムにもアクセスできなくなる）
+func (pc *PickerClient) DeleteSession(ctx context.Context, sessionName string) error {
+	url := fmt.Sprintf("%s/%s", pc.baseURL, sessionNameFromID(sessionName))
+	
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	
+	resp, err := pc.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return newAPIError(resp)
+	}
+	
+	return nil
+}
+
+// sessionNameから sessionId を抽出 (sessions/xxxxx-xxxx -> xxxxx-xxxx)
+func sessionIDFromName(sessionName string) string {
+	return strings.TrimPrefix(sessionName, "sessions/")
+}
+
+// sessionId から sessionName を生成 (xxxxx-xxxx -> sessions/xxxxx-xxxx)
+func sessionNameFromID(sessionID string) string {
+	if strings.HasPrefix(sessionID, "sessions/") {
+		return sessionID
+	}
+	return "sessions/" + sessionID
+}
+
+// 選択されたメディアアイテムを全ページ分取得
 func (pc *PickerClient) ListMediaItems(ctx context.Context, sessionName string) ([]MediaItem, error) {
-	// sessionNameから sessionId を抽出 (sessions/xxxxx-xxxx -> xxxxx-xxxx)
-	sessionId := sessionName
-	if strings.HasPrefix(sessionName, "sessions/") {
-		sessionId = strings.TrimPrefix(sessionName, "sessions/")
+	var mediaItems []MediaItem
+	for item, err := range pc.AllMediaItems(ctx, sessionName) {
+		if err != nil {
+			return nil, err
+		}
+		mediaItems = append(mediaItems, item)
+	}
+	return mediaItems, nil
+}
+
+// 選択されたメディアアイテムをページを辿りながら1件ずつ返すイテレータ
+// 同じ nextPageToken が再び返された場合は、無限ループにならないようエラーにする
+func (pc *PickerClient) AllMediaItems(ctx context.Context, sessionName string) iter.Seq2[MediaItem, error] {
+	return func(yield func(MediaItem, error) bool) {
+		pageToken := ""
+		seenTokens := map[string]bool{}
+		for {
+			page, err := pc.ListMediaItemsPage(ctx, sessionName, pageToken)
+			if err != nil {
+				yield(MediaItem{}, err)
+				return
+			}
+
+			for _, item := range page.MediaItems {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if page.NextPageToken == "" {
+				return
+			}
+			if seenTokens[page.NextPageToken] {
+				yield(MediaItem{}, fmt.Errorf("media item listing returned page token %q more than once", page.NextPageToken))
+				return
+			}
+			seenTokens[page.NextPageToken] = true
+			pageToken = page.NextPageToken
+		}
 	}
+}
+
+// 選択されたメディアアイテムを1ページ分取得
+func (pc *PickerClient) ListMediaItemsPage(ctx context.Context, sessionName, pageToken string) (*MediaItemsResponse, error) {
+	sessionId := sessionIDFromName(sessionName)
 	
 	// 正しいエンドポイント: /v1/mediaItems?sessionId=xxx
-	url := fmt.Sprintf("https://photospicker.googleapis.com/v1/mediaItems?sessionId=%s", sessionId)
+	query := url.Values{}
+	query.Set("sessionId", sessionId)
+	if pc.pageSize > 0 {
+		query.Set("pageSize", strconv.Itoa(pc.pageSize))
+	}
+	if pageToken != "" {
+		query.Set("pageToken", pageToken)
+	}
+	endpoint := pc.baseURL + "/mediaItems?" + query.Encode()
 	
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	
-	req.Header.Set("Authorization", "Bearer "+pc.accessToken)
-	
 	resp, err := pc.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp)
 	}
 	
 	var response MediaItemsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 	
-	return response.MediaItems, nil
+	return &response, nil
 }
 
-func (pc *PickerClient) WaitForSelection(ctx context.Context, sessionName string) error {
-	fmt.Println("ユーザーの写真選択を待っています...")
-	
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-	
-	timeout := time.After(10 * time.Minute)
-	
+// サーバーの pollingConfig に従って写真選択の完了を待つ
+// 一時的なエラー（5xx/429）はバックオフしながら再試行する
+// 待機中のメッセージは progress に書き出す
+func (pc *PickerClient) WaitForSelection(ctx context.Context, session *PickerSession, progress io.Writer) error {
+	fmt.Fprintln(progress, "ユーザーの写真選択を待っています...")
+
+	// timeoutIn は取得時点からの残り時間なので、期限は一度だけ決める
+	// 作成・取得済みのセッションが期限を持たない場合は最初のポーリング結果から決める
+	deadline, known := session.deadline()
+	retries := 0
+
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-timeout:
-			return fmt.Errorf("写真選択がタイムアウトしました")
-		case <-ticker.C:
-			session, err := pc.GetSession(ctx, sessionName)
-			if err != nil {
-				return fmt.Errorf("セッション取得エラー: %v", err)
+		polled, err := pc.GetSession(ctx, session.Name)
+		if err != nil {
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || !apiErr.Temporary() || retries >= maxPollRetries {
+				return fmt.Errorf("セッション取得エラー: %w", err)
+			}
+
+			// 一時的なエラーは待ってから再試行（セッションの期限は超えない）
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return fmt.Errorf("写真選択がタイムアウトしました: %w", err)
+			}
+			delay := min(retryDelay(retries, apiErr.RetryAfter), remaining)
+			retries++
+			fmt.Fprintf(progress, "一時的なエラーが発生しました (%d)。%v 後に再試行します (%d/%d)\n", apiErr.StatusCode, delay, retries, maxPollRetries)
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
 			}
-			
-			if session.MediaItemsSet {
-				fmt.Println("写真が選択されました！")
-				return nil
+			continue
+		}
+		retries = 0
+
+		if polled.MediaItemsSet {
+			fmt.Fprintln(progress, "写真が選択されました！")
+			return nil
+		}
+
+		// サーバーの指定する間隔と期限に従う
+		if !known {
+			if d, ok := polled.deadline(); ok {
+				deadline, known = d, true
 			}
 		}
+
+		wait := polled.pollInterval()
+		if remaining := time.Until(deadline); remaining <= 0 {
+			return fmt.Errorf("写真選択がタイムアウトしました")
+		} else if remaining < wait {
+			wait = remaining
+		}
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// 再試行までの待ち時間（Retry-After があれば優先し、なければ指数バックオフ）
+func retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, maxRetryBackoff)
 	}
-}
\ No newline at end of file
+	return min(initialRetryDelay<<attempt, maxRetryBackoff)
+}
+
+// キャンセル可能なスリープ
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultProfileName = "default"
+
+// --profile フラグで指定されたプロファイル
+var flagProfile string
+
+// プロファイル名に使える文字（認証情報ストアのキーやファイル名に使うため制限する）
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+var configProfilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Manage named profiles (Google accounts)",
+	Long:  "Each profile has its own OAuth client, token, auth method and download defaults",
+}
+
+var configProfilesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runProfilesList(); err != nil {
+			return fmt.Errorf("Error listing profiles: %w", err)
+		}
+		return nil
+	},
+}
+
+var configProfilesAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a profile and run the interactive setup for it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runProfilesAdd(args[0]); err != nil {
+			return fmt.Errorf("Error adding profile: %w", err)
+		}
+		return nil
+	},
+}
+
+var configProfilesRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a profile together with its stored token and client secret",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runProfilesRemove(args[0]); err != nil {
+			return fmt.Errorf("Error removing profile: %w", err)
+		}
+		return nil
+	},
+}
+
+var configProfilesUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Switch the default profile",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runProfilesUse(args[0]); err != nil {
+			return fmt.Errorf("Error switching profile: %w", err)
+		}
+		return nil
+	},
+}
+
+// 使用するプロファイルを フラグ > 環境変数 GPHOTO_PROFILE > 設定ファイルの current_profile の優先順で決定
+func activeProfileName(cf *configFile) string {
+	if flagProfile != "" {
+		return flagProfile
+	}
+	if envProfile := os.Getenv("GPHOTO_PROFILE"); envProfile != "" {
+		return envProfile
+	}
+	if cf != nil && cf.CurrentProfile != "" {
+		return cf.CurrentProfile
+	}
+	return defaultProfileName
+}
+
+// 設定ファイルを読み込んで使用中のプロファイル名を取得
+func currentProfileName() string {
+	cf, _ := loadConfigFile()
+	return activeProfileName(cf)
+}
+
+func validateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (use letters, digits, '-' and '_')", name)
+	}
+	return nil
+}
+
+// プロファイルごとに認証情報ストアのキーを分ける
+// default プロファイルは以前と同じキー（token.json など）を使う
+type profileScopedStore struct {
+	credentialStore
+	profile string
+}
+
+func (s profileScopedStore) key(key string) string {
+	if s.profile == defaultProfileName {
+		return key
+	}
+	return key + "." + s.profile
+}
+
+func (s profileScopedStore) Get(key string) ([]byte, error) {
+	return s.credentialStore.Get(s.key(key))
+}
+
+func (s profileScopedStore) Set(key string, data []byte) error {
+	return s.credentialStore.Set(s.key(key), data)
+}
+
+func (s profileScopedStore) Delete(key string) error {
+	return s.credentialStore.Delete(s.key(key))
+}
+
+// プロファイルのトークンとクライアントシークレットを削除
+func removeProfileCredentials(name string, config *Config) {
+	store, err := newCredentialStore(credentialStoreName(config), name)
+	if err != nil {
+		fmt.Printf("Warning: failed to open credential store: %v\n", err)
+		return
+	}
+	for _, key := range []string{credentialKeyToken, credentialKeyClientSecret} {
+		if err := store.Delete(key); err != nil {
+			fmt.Printf("Warning: failed to remove %s from %s: %v\n", key, store.Name(), err)
+		}
+	}
+}
+
+// プロファイルを設定ファイルから削除し、最後のプロファイルだった場合は設定ファイルごと削除する
+func deleteProfile(cf *configFile, name string) error {
+	removeProfileCredentials(name, cf.Profiles[name])
+	delete(cf.Profiles, name)
+	if cf.CurrentProfile == name {
+		cf.CurrentProfile = ""
+	}
+
+	if len(cf.Profiles) > 0 {
+		return saveConfigFile(cf)
+	}
+
+	configPath, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove config file: %w", err)
+	}
+	// すべてのプロファイルで共有する暗号化ファイルも不要になる
+	dataDir, err := getDataDir()
+	if err != nil {
+		return err
+	}
+	encryptedPath := filepath.Join(dataDir, encryptedCredentialFile)
+	if err := os.Remove(encryptedPath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: failed to remove credentials file: %v\n", err)
+	}
+	return nil
+}
+
+func runProfilesList() error {
+	cf, err := loadConfigFile()
+	if err != nil {
+		return err
+	}
+
+	if len(cf.Profiles) == 0 {
+		fmt.Println("プロファイルはありません（作成するには: ./gphoto-cli setup）")
+		return nil
+	}
+
+	active := activeProfileName(cf)
+	names := make([]string, 0, len(cf.Profiles))
+	for name := range cf.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		config := cf.Profiles[name]
+		marker := "  "
+		if name == active {
+			marker = "* "
+		}
+		fmt.Printf("%s%s\n", marker, name)
+		fmt.Printf("     Client ID: %s\n", maskString(config.GoogleClientID))
+		authMethod := config.AuthMethod
+		if authMethod == "" {
+			authMethod = "server"
+		}
+		fmt.Printf("     認証方式: %s / 保存先: %s\n", authMethod, credentialStoreName(config))
+	}
+
+	return nil
+}
+
+func runProfilesAdd(name string) error {
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+
+	cf, err := loadConfigFile()
+	if err != nil {
+		return err
+	}
+	if _, ok := cf.Profiles[name]; ok {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+
+	// 追加するプロファイルを対象にセットアップする
+	flagProfile = name
+	if err := runInteractiveSetup(); err != nil {
+		return err
+	}
+
+	fmt.Printf("このプロファイルを使うには: ./gphoto-cli --profile %s <command> または ./gphoto-cli config profiles use %s\n", name, name)
+	return nil
+}
+
+func runProfilesRemove(name string) error {
+	cf, err := loadConfigFile()
+	if err != nil {
+		return err
+	}
+	if _, ok := cf.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	if name == activeProfileName(cf) && len(cf.Profiles) > 1 {
+		return errors.New("cannot remove the profile in use; switch to another profile first with 'config profiles use'")
+	}
+
+	if err := deleteProfile(cf, name); err != nil {
+		return err
+	}
+
+	fmt.Printf("🗑️  プロファイルを削除しました: %s\n", name)
+	return nil
+}
+
+func runProfilesUse(name string) error {
+	cf, err := loadConfigFile()
+	if err != nil {
+		return err
+	}
+	if _, ok := cf.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q not found (add it with 'config profiles add %s')", name, name)
+	}
+
+	cf.CurrentProfile = name
+	if err := saveConfigFile(cf); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ プロファイル %s を使用します\n", name)
+	if envProfile := os.Getenv("GPHOTO_PROFILE"); envProfile != "" && envProfile != name {
+		fmt.Printf("⚠️  環境変数 GPHOTO_PROFILE=%s が設定されているため、そちらが優先されます\n", envProfile)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&flagProfile, "profile", "", "Profile to use (env: GPHOTO_PROFILE, default: current_profile in config.yaml)")
+
+	configProfilesCmd.AddCommand(configProfilesListCmd)
+	configProfilesCmd.AddCommand(configProfilesAddCmd)
+	configProfilesCmd.AddCommand(configProfilesRemoveCmd)
+	configProfilesCmd.AddCommand(configProfilesUseCmd)
+
+	configCmd.AddCommand(configProfilesCmd)
+}
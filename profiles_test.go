@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+)
+
+func TestLegacyConfigBecomesDefaultProfile(t *testing.T) {
+	setTestHome(t)
+	t.Setenv("GPHOTO_PROFILE", "")
+
+	configPath := mustConfigPath(t)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	legacy := "google_client_id: legacy-id\ngoogle_client_secret: legacy-secret\nauth_method: device\n"
+	if err := os.WriteFile(configPath, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := loadConfigFile()
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	if len(cf.Profiles) != 1 || cf.Profiles[defaultProfileName] == nil {
+		t.Fatalf("profiles = %v, want only %q", cf.Profiles, defaultProfileName)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.GoogleClientID != "legacy-id" || config.AuthMethod != "device" {
+		t.Errorf("got client id %q, auth method %q", config.GoogleClientID, config.AuthMethod)
+	}
+}
+
+func TestProfilesHaveSeparateTokens(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+	t.Setenv("GPHOTO_PROFILE", "")
+	t.Cleanup(func() { flagProfile = "" })
+
+	// 2つ目のプロファイルを追加
+	flagProfile = "work"
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.GoogleClientID = "work-client-id"
+	config.GoogleClientSecret = "work-client-secret"
+	config.Download.Concurrency = 2
+	if err := saveConfig(config); err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+	if err := saveToken(&oauth2.Token{AccessToken: "work-token", RefreshToken: "work-refresh"}, io.Discard); err != nil {
+		t.Fatalf("saveToken: %v", err)
+	}
+	flagProfile = ""
+
+	tok, err := loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != fake.accessToken {
+		t.Errorf("default profile token = %q, want %q", tok.AccessToken, fake.accessToken)
+	}
+
+	// 環境変数でもプロファイルを切り替えられる
+	t.Setenv("GPHOTO_PROFILE", "work")
+	tok, err = loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "work-token" {
+		t.Errorf("work profile token = %q, want %q", tok.AccessToken, "work-token")
+	}
+	t.Setenv("GPHOTO_PROFILE", "")
+
+	// current_profile を切り替えると設定とダウンロードのデフォルトも切り替わる
+	if err := runProfilesUse("work"); err != nil {
+		t.Fatalf("runProfilesUse: %v", err)
+	}
+	config, err = loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.GoogleClientID != "work-client-id" {
+		t.Errorf("client id = %q, want work-client-id", config.GoogleClientID)
+	}
+
+	cmd := &cobra.Command{}
+	addDownloadFlags(cmd)
+	if opts, _ := downloadOptionsFromFlags(cmd); opts.Concurrency != 2 {
+		t.Errorf("concurrency = %d, want the profile default 2", opts.Concurrency)
+	}
+	cmd.Flags().Set("concurrency", "6")
+	if opts, _ := downloadOptionsFromFlags(cmd); opts.Concurrency != 6 {
+		t.Errorf("concurrency = %d, want the flag value 6", opts.Concurrency)
+	}
+
+	// 使用中のプロファイルは削除できない
+	if err := runProfilesRemove("work"); err == nil {
+		t.Error("removing the active profile should fail")
+	}
+	if err := runProfilesUse(defaultProfileName); err != nil {
+		t.Fatal(err)
+	}
+	if err := runProfilesRemove("work"); err != nil {
+		t.Fatalf("runProfilesRemove: %v", err)
+	}
+
+	flagProfile = "work"
+	if _, err := loadToken(); !errors.Is(err, errCredentialNotFound) {
+		t.Errorf("work token after remove: err = %v, want errCredentialNotFound", err)
+	}
+	flagProfile = ""
+	if _, err := loadToken(); err != nil {
+		t.Errorf("default profile token should remain: %v", err)
+	}
+}
+
+func TestInvalidProfileName(t *testing.T) {
+	setTestHome(t)
+	t.Cleanup(func() { flagProfile = "" })
+
+	flagProfile = "../escape"
+	if _, err := activeCredentialStore(); err == nil {
+		t.Error("activeCredentialStore should reject an invalid profile name")
+	}
+}
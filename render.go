@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// プレビューの描画方式
+const (
+	rendererAuto      = "auto"
+	rendererKitty     = "kitty"
+	rendererITerm2    = "iterm2"
+	rendererSixel     = "sixel"
+	rendererTruecolor = "truecolor"
+	rendererASCII     = "ascii"
+)
+
+// ASCII プレビューの色
+const (
+	asciiColorNone      = "none"
+	asciiColor256       = "256"
+	asciiColorTruecolor = "truecolor"
+)
+
+// ターミナルから取得できない場合の1文字の縦横比（縦/横）と横幅（ピクセル）の目安
+const (
+	defaultCellAspect = 2.0
+	defaultCellWidth  = 10
+)
+
+// 画像を描画する領域
+type renderBox struct {
+	// 横幅（文字数）
+	Cols int
+	// 高さ（行数、0 の場合は制限しない）
+	Rows int
+	// 1文字の縦横比（縦/横）
+	CellAspect float64
+	// 1文字の横幅（ピクセル、Sixel で使用）
+	CellWidth int
+}
+
+// 縦横比を保ったまま領域に収まる文字数を計算
+func (b renderBox) fit(bounds image.Rectangle) (cols, rows int) {
+	cellAspect := b.CellAspect
+	if cellAspect <= 0 {
+		cellAspect = defaultCellAspect
+	}
+	// 横1文字あたりに必要な行数
+	rowsPerCol := float64(bounds.Dy()) / float64(bounds.Dx()) / cellAspect
+
+	cols = b.Cols
+	rows = int(math.Round(float64(cols) * rowsPerCol))
+	if b.Rows > 0 && rows > b.Rows {
+		rows = b.Rows
+		cols = int(math.Round(float64(rows) / rowsPerCol))
+	}
+	return max(cols, 1), max(rows, 1)
+}
+
+// 画像をターミナルに描画する
+type imageRenderer interface {
+	Name() string
+	Render(w io.Writer, img image.Image, box renderBox) error
+}
+
+func validateASCIIColor(mode string) error {
+	switch mode {
+	case asciiColorNone, asciiColor256, asciiColorTruecolor:
+		return nil
+	}
+	return fmt.Errorf("unknown ASCII color mode %q (want none, 256 or truecolor)", mode)
+}
+
+func validateRenderer(name string) error {
+	switch name {
+	case rendererAuto, rendererKitty, rendererITerm2, rendererSixel, rendererTruecolor, rendererASCII:
+		return nil
+	}
+	return fmt.Errorf("unknown renderer %q (want auto, kitty, iterm2, sixel, truecolor or ascii)", name)
+}
+
+// 名前からレンダラーを作成（auto の場合はターミナルから判定）
+// asciiColor は ascii で描画する場合の色（none / 256 / truecolor）
+func newImageRenderer(name, asciiColor string) (imageRenderer, error) {
+	if name == "" || name == rendererAuto {
+		name = detectRenderer(os.Getenv)
+	}
+
+	switch name {
+	case rendererKitty:
+		return kittyRenderer{}, nil
+	case rendererITerm2:
+		return iterm2Renderer{}, nil
+	case rendererSixel:
+		return sixelRenderer{}, nil
+	case rendererTruecolor:
+		return halfBlockRenderer{}, nil
+	case rendererASCII:
+		if asciiColor != "" {
+			if err := validateASCIIColor(asciiColor); err != nil {
+				return nil, err
+			}
+		}
+		return asciiRenderer{Color: asciiColor}, nil
+	}
+	return nil, validateRenderer(name)
+}
+
+// 環境変数からターミナルが対応している描画方式を判定
+func detectRenderer(getenv func(string) string) string {
+	termName := getenv("TERM")
+	termProgram := getenv("TERM_PROGRAM")
+
+	// tmux / screen 内ではエスケープシーケンスがそのまま届かないため画像プロトコルは使わない
+	multiplexed := getenv("TMUX") != "" || strings.HasPrefix(termName, "screen") || strings.HasPrefix(termName, "tmux")
+	if !multiplexed {
+		switch {
+		case getenv("KITTY_WINDOW_ID") != "" || termName == "xterm-kitty" || termProgram == "ghostty":
+			return rendererKitty
+		case termProgram == "iTerm.app" || getenv("LC_TERMINAL") == "iTerm2" || termProgram == "WezTerm":
+			return rendererITerm2
+		case strings.Contains(termName, "sixel") || termName == "foot" || termName == "mlterm" || termProgram == "mlterm":
+			return rendererSixel
+		}
+	}
+
+	switch getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return rendererTruecolor
+	}
+	return rendererASCII
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ターミナルの大きさ
+type terminalDimensions struct {
+	Cols, Rows int
+	// ウィンドウのピクセル数（取得できない場合は 0）
+	PixelWidth, PixelHeight int
+}
+
+// ピクセル数から1文字の縦横比（縦/横）を計算（不明な場合は 0）
+func (d terminalDimensions) cellAspect() float64 {
+	if d.Cols == 0 || d.Rows == 0 || d.PixelWidth == 0 || d.PixelHeight == 0 {
+		return 0
+	}
+	return (float64(d.PixelHeight) / float64(d.Rows)) / (float64(d.PixelWidth) / float64(d.Cols))
+}
+
+// 10段階のグレースケールの文字で描画（Color を指定すると文字に色を付ける）
+type asciiRenderer struct {
+	Color string
+}
+
+func (asciiRenderer) Name() string { return rendererASCII }
+
+func (r asciiRenderer) Render(w io.Writer, img image.Image, box renderBox) error {
+	// 枠線の分を除いた領域に縦横比を保って収める
+	inner := box
+	inner.Cols = max(box.Cols-2, 1)
+	if box.Rows > 0 {
+		inner.Rows = max(box.Rows-2, 1)
+	}
+	cols, rows := inner.fit(img.Bounds())
+	resized := resize.Resize(uint(cols), uint(rows), img, resize.Lanczos3)
+
+	// ASCII文字のパレット（暗→明）
+	palette := " .:-=+*#%@"
+
+	var sb strings.Builder
+	// 枠線は文字数（バイト数ではない）で揃える
+	sb.WriteString("┌" + strings.Repeat("─", cols) + "┐\n")
+
+	bounds := resized.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		sb.WriteString("│")
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(resized.At(x, y)).(color.NRGBA)
+			// RGBから輝度を計算（0-255）
+			gray := 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+
+			// 輝度に基づいてASCII文字を選択
+			index := min(int(gray*float64(len(palette))/256), len(palette)-1)
+			switch r.Color {
+			case asciiColorTruecolor:
+				fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm", c.R, c.G, c.B)
+			case asciiColor256:
+				fmt.Fprintf(&sb, "\x1b[38;5;%dm", ansi256Index(c))
+			}
+			sb.WriteByte(palette[index])
+		}
+		if r.Color != "" && r.Color != asciiColorNone {
+			sb.WriteString("\x1b[0m")
+		}
+		sb.WriteString("│\n")
+	}
+
+	sb.WriteString("└" + strings.Repeat("─", cols) + "┘\n")
+	original := img.Bounds()
+	fmt.Fprintf(&sb, "Image: %dx%d pixels\n", original.Dx(), original.Dy())
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ANSI 256 色で最も近い色の番号（6x6x6 のカラーキューブまたはグレースケール）
+func ansi256Index(c color.NRGBA) int {
+	// 彩度がほとんどない場合はグレースケールの24段階を使う
+	if max(c.R, c.G, c.B)-min(c.R, c.G, c.B) < 10 {
+		gray := (int(c.R) + int(c.G) + int(c.B)) / 3
+		switch {
+		case gray < 8:
+			return 16
+		case gray > 238:
+			return 231
+		}
+		return 232 + (gray-8)*24/231
+	}
+	level := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+	return 16 + level(c.R)*36 + level(c.G)*6 + level(c.B)
+}
+
+// 24bit カラーの上半分ブロック（▀）で1文字に縦2ピクセルを描画
+type halfBlockRenderer struct{}
+
+func (halfBlockRenderer) Name() string { return rendererTruecolor }
+
+func (halfBlockRenderer) Render(w io.Writer, img image.Image, box renderBox) error {
+	// 1文字に縦2ピクセルを描画する
+	cols, rows := box.fit(img.Bounds())
+	resized := resize.Resize(uint(cols), uint(rows*2), img, resize.Lanczos3)
+	bounds := resized.Bounds()
+
+	var sb strings.Builder
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			top := color.NRGBAModel.Convert(resized.At(x, y)).(color.NRGBA)
+			fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm", top.R, top.G, top.B)
+			if y+1 < bounds.Max.Y {
+				bottom := color.NRGBAModel.Convert(resized.At(x, y+1)).(color.NRGBA)
+				fmt.Fprintf(&sb, "\x1b[48;2;%d;%d;%dm", bottom.R, bottom.G, bottom.B)
+			} else {
+				sb.WriteString("\x1b[49m")
+			}
+			sb.WriteString("▀")
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Kitty graphics protocol（PNG を base64 で分割して送る）
+type kittyRenderer struct{}
+
+func (kittyRenderer) Name() string { return rendererKitty }
+
+func (kittyRenderer) Render(w io.Writer, img image.Image, box renderBox) error {
+	// 横幅だけ指定すると縦横比を保って拡大縮小される
+	cols, _ := box.fit(img.Bounds())
+
+	data, err := encodePNG(img)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var sb strings.Builder
+	// 前に表示した画像を消してから表示する（q=2 で端末からの応答を抑止）
+	sb.WriteString("\x1b_Ga=d,q=2\x1b\\")
+	const chunkSize = 4096
+	for offset := 0; offset < len(encoded); offset += chunkSize {
+		chunk := encoded[offset:min(offset+chunkSize, len(encoded))]
+		more := 0
+		if offset+chunkSize < len(encoded) {
+			more = 1
+		}
+		if offset == 0 {
+			fmt.Fprintf(&sb, "\x1b_Ga=T,f=100,q=2,c=%d,m=%d;%s\x1b\\", cols, more, chunk)
+		} else {
+			fmt.Fprintf(&sb, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	sb.WriteString("\n")
+
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// iTerm2 のインライン画像（OSC 1337）
+type iterm2Renderer struct{}
+
+func (iterm2Renderer) Name() string { return rendererITerm2 }
+
+func (iterm2Renderer) Render(w io.Writer, img image.Image, box renderBox) error {
+	cols, rows := box.fit(img.Bounds())
+
+	data, err := encodePNG(img)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1:%s\a\n",
+		len(data), cols, rows, base64.StdEncoding.EncodeToString(data))
+	return err
+}
+
+// Sixel（6x6x6 の 216 色に減色して描画）
+type sixelRenderer struct{}
+
+func (sixelRenderer) Name() string { return rendererSixel }
+
+func (sixelRenderer) Render(w io.Writer, img image.Image, box renderBox) error {
+	// 描画する文字数をピクセル数に換算（縦も横と同じ比率で縮小）
+	cols, _ := box.fit(img.Bounds())
+	cellWidth := box.CellWidth
+	if cellWidth <= 0 {
+		cellWidth = defaultCellWidth
+	}
+	bounds := img.Bounds()
+	pixelWidth := cols * cellWidth
+	resized := resize.Resize(uint(pixelWidth), uint(max(pixelWidth*bounds.Dy()/bounds.Dx(), 1)), img, resize.Lanczos3)
+	bounds = resized.Bounds()
+	pixelWidth, pixelHeight := bounds.Dx(), bounds.Dy()
+
+	// 各ピクセルのパレット番号
+	indexes := make([]int, pixelWidth*pixelHeight)
+	for y := 0; y < pixelHeight; y++ {
+		for x := 0; x < pixelWidth; x++ {
+			c := color.NRGBAModel.Convert(resized.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			indexes[y*pixelWidth+x] = sixelColorIndex(c)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\x1bPq\"1;1;%d;%d", pixelWidth, pixelHeight)
+	for i := 0; i < 216; i++ {
+		r, g, b := i/36, i/6%6, i%6
+		fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", i, r*20, g*20, b*20)
+	}
+
+	// 6行ずつの帯ごとに、使われている色を1色ずつ重ね描きする
+	for top := 0; top < pixelHeight; top += 6 {
+		used := map[int]bool{}
+		for y := top; y < min(top+6, pixelHeight); y++ {
+			for x := 0; x < pixelWidth; x++ {
+				used[indexes[y*pixelWidth+x]] = true
+			}
+		}
+
+		for colorIndex := 0; colorIndex < 216; colorIndex++ {
+			if !used[colorIndex] {
+				continue
+			}
+			fmt.Fprintf(&sb, "#%d", colorIndex)
+
+			run, runChar := 0, byte(0)
+			flush := func() {
+				switch {
+				case run > 3:
+					fmt.Fprintf(&sb, "!%d%c", run, runChar)
+				case run > 0:
+					sb.WriteString(strings.Repeat(string(runChar), run))
+				}
+			}
+			for x := 0; x < pixelWidth; x++ {
+				bits := 0
+				for dy := 0; dy < 6 && top+dy < pixelHeight; dy++ {
+					if indexes[(top+dy)*pixelWidth+x] == colorIndex {
+						bits |= 1 << dy
+					}
+				}
+				char := byte(63 + bits)
+				if char != runChar {
+					flush()
+					run, runChar = 0, char
+				}
+				run++
+			}
+			flush()
+			// 同じ帯の先頭に戻る
+			sb.WriteString("$")
+		}
+		// 次の帯へ
+		sb.WriteString("-")
+	}
+	sb.WriteString("\x1b\\\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// 6x6x6 のカラーキューブで最も近い色の番号
+func sixelColorIndex(c color.NRGBA) int {
+	level := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+	return level(c.R)*36 + level(c.G)*6 + level(c.B)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestDetectRenderer(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "kitty", env: map[string]string{"TERM": "xterm-kitty"}, want: rendererKitty},
+		{name: "kitty window", env: map[string]string{"KITTY_WINDOW_ID": "1", "TERM": "xterm-256color"}, want: rendererKitty},
+		{name: "iterm2", env: map[string]string{"TERM_PROGRAM": "iTerm.app"}, want: rendererITerm2},
+		{name: "wezterm", env: map[string]string{"TERM_PROGRAM": "WezTerm"}, want: rendererITerm2},
+		{name: "sixel", env: map[string]string{"TERM": "foot"}, want: rendererSixel},
+		{name: "truecolor", env: map[string]string{"TERM": "xterm-256color", "COLORTERM": "truecolor"}, want: rendererTruecolor},
+		{name: "tmux disables graphics protocols", env: map[string]string{"TERM_PROGRAM": "iTerm.app", "TMUX": "/tmp/tmux", "COLORTERM": "24bit"}, want: rendererTruecolor},
+		{name: "plain", env: map[string]string{"TERM": "xterm"}, want: rendererASCII},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectRenderer(func(name string) string { return tt.env[name] })
+			if got != tt.want {
+				t.Errorf("detectRenderer = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderers(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 6), G: uint8(y * 12), B: 128, A: 255})
+		}
+	}
+
+	tests := []struct {
+		renderer imageRenderer
+		prefix   string
+		suffix   string
+	}{
+		{renderer: kittyRenderer{}, prefix: "\x1b_Ga=d,q=2\x1b\\\x1b_Ga=T,f=100,q=2,c=20,", suffix: "\x1b\\\n"},
+		{renderer: iterm2Renderer{}, prefix: "\x1b]1337;File=inline=1;", suffix: "\a\n"},
+		{renderer: sixelRenderer{}, prefix: "\x1bPq\"1;1;200;100", suffix: "\x1b\\\n"},
+		{renderer: halfBlockRenderer{}, prefix: "\x1b[38;2;", suffix: "\x1b[0m\n"},
+		{renderer: asciiRenderer{}, prefix: "┌", suffix: "pixels\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.renderer.Name(), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.renderer.Render(&buf, img, renderBox{Cols: 20}); err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			out := buf.String()
+			if !strings.HasPrefix(out, tt.prefix) {
+				t.Errorf("output starts with %q, want %q", out[:min(len(out), 60)], tt.prefix)
+			}
+			if !strings.HasSuffix(out, tt.suffix) {
+				t.Errorf("output ends with %q, want %q", out[max(0, len(out)-20):], tt.suffix)
+			}
+		})
+	}
+}
+
+func TestHalfBlockRendererKeepsAspectRatio(t *testing.T) {
+	// 40x20 の画像を横20文字で描画すると 20x10 ピクセル = 5行
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	var buf bytes.Buffer
+	if err := (halfBlockRenderer{}).Render(&buf, img, renderBox{Cols: 20}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Errorf("rendered %d lines, want 5", len(lines))
+	}
+	if got := strings.Count(lines[0], "▀"); got != 20 {
+		t.Errorf("rendered %d cells per line, want 20", got)
+	}
+}
+
+func TestITerm2RendererSendsPNG(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	var buf bytes.Buffer
+	if err := (iterm2Renderer{}).Render(&buf, img, renderBox{Cols: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, payload, _ := strings.Cut(buf.String(), ":")
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(payload, "\a\n"))
+	if err != nil {
+		t.Fatalf("payload is not base64: %v", err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("payload is not a PNG: %v", err)
+	}
+}
+
+func TestNewImageRendererRejectsUnknown(t *testing.T) {
+	if _, err := newImageRenderer("braille", ""); err == nil {
+		t.Error("newImageRenderer should reject an unknown renderer")
+	}
+	if _, err := newImageRenderer(rendererASCII, "16"); err == nil {
+		t.Error("newImageRenderer should reject an unknown ASCII color mode")
+	}
+}
+
+func TestRenderBoxFit(t *testing.T) {
+	tests := []struct {
+		name     string
+		box      renderBox
+		bounds   image.Rectangle
+		wantCols int
+		wantRows int
+	}{
+		{name: "landscape", box: renderBox{Cols: 80}, bounds: image.Rect(0, 0, 400, 200), wantCols: 80, wantRows: 20},
+		{name: "portrait", box: renderBox{Cols: 80}, bounds: image.Rect(0, 0, 200, 400), wantCols: 80, wantRows: 80},
+		{name: "portrait limited by rows", box: renderBox{Cols: 80, Rows: 20}, bounds: image.Rect(0, 0, 200, 400), wantCols: 20, wantRows: 20},
+		{name: "square cells", box: renderBox{Cols: 40, CellAspect: 1}, bounds: image.Rect(0, 0, 400, 200), wantCols: 40, wantRows: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols, rows := tt.box.fit(tt.bounds)
+			if cols != tt.wantCols || rows != tt.wantRows {
+				t.Errorf("fit = %dx%d, want %dx%d", cols, rows, tt.wantCols, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestASCIIRendererBorders(t *testing.T) {
+	// 縦長の画像でも縦横比を保ち、枠線の幅が全行で揃う
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 60))
+	for _, mode := range []string{asciiColorNone, asciiColor256, asciiColorTruecolor} {
+		t.Run(mode, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := (asciiRenderer{Color: mode}).Render(&buf, img, renderBox{Cols: 32, Rows: 20}); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			footer := lines[len(lines)-1]
+			if footer != "Image: 20x60 pixels" {
+				t.Errorf("footer = %q", footer)
+			}
+			// 上下の枠線を含めて20行、60/20/2 = 1.5 行/文字なので 12 文字
+			lines = lines[:len(lines)-1]
+			if len(lines) != 20 {
+				t.Fatalf("rendered %d lines, want 20", len(lines))
+			}
+			for i, line := range lines {
+				plain := ansiEscape.ReplaceAllString(line, "")
+				if got := utf8.RuneCountInString(plain); got != 14 {
+					t.Errorf("line %d is %d cells wide, want 14: %q", i, got, plain)
+				}
+			}
+			if mode != asciiColorNone && !strings.HasSuffix(lines[1], "\x1b[0m│") {
+				t.Errorf("colored line should reset before the border: %q", lines[1])
+			}
+		})
+	}
+}
+
+func TestANSI256Index(t *testing.T) {
+	tests := []struct {
+		c    color.NRGBA
+		want int
+	}{
+		{c: color.NRGBA{R: 255, A: 255}, want: 196},
+		{c: color.NRGBA{G: 255, B: 255, A: 255}, want: 51},
+		{c: color.NRGBA{A: 255}, want: 16},
+		{c: color.NRGBA{R: 128, G: 128, B: 128, A: 255}, want: 244},
+		{c: color.NRGBA{R: 255, G: 255, B: 255, A: 255}, want: 231},
+	}
+
+	for _, tt := range tests {
+		if got := ansi256Index(tt.c); got != tt.want {
+			t.Errorf("ansi256Index(%v) = %d, want %d", tt.c, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// ローカルで追跡している Picker セッション
+type trackedSession struct {
+	ID         string    `json:"id"`
+	PickerUri  string    `json:"pickerUri"`
+	Command    string    `json:"command"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpireTime string    `json:"expireTime,omitempty"`
+	// セッションを作成したプロファイル（空の場合は default）
+	Profile string `json:"profile,omitempty"`
+}
+
+// 指定したプロファイルのセッションか
+func (s trackedSession) belongsTo(profile string) bool {
+	if s.Profile == "" {
+		return profile == defaultProfileName
+	}
+	return s.Profile == profile
+}
+
+// 期限切れかどうか（期限が不明な場合は false）
+func (s trackedSession) expired() bool {
+	expire, err := time.Parse(time.RFC3339, s.ExpireTime)
+	return err == nil && time.Now().After(expire)
+}
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage Google Photos Picker sessions",
+	Long:  "List, inspect, delete or resume Picker sessions created by gphoto-cli",
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List locally tracked picker sessions",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runSessionsList(); err != nil {
+			return fmt.Errorf("Error listing sessions: %w", err)
+		}
+		return nil
+	},
+}
+
+var sessionsShowCmd = &cobra.Command{
+	Use:   "show <session-id>",
+	Short: "Show the current state of a picker session",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runSessionsShow(args[0]); err != nil {
+			return fmt.Errorf("Error showing session: %w", err)
+		}
+		return nil
+	},
+}
+
+var sessionsDeleteCmd = &cobra.Command{
+	Use:   "delete <session-id>...",
+	Short: "Delete picker sessions",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		if !all && len(args) == 0 {
+			return errors.New("Error deleting sessions: specify session IDs or --all")
+		}
+		if err := runSessionsDelete(args, all); err != nil {
+			return fmt.Errorf("Error deleting sessions: %w", err)
+		}
+		return nil
+	},
+}
+
+var sessionsResumeCmd = &cobra.Command{
+	Use:   "resume <session-id>",
+	Short: "Download the selection of an existing picker session without picking again",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// 設定確認
+		if !isConfigured() {
+			return ErrNotConfigured
+		}
+
+		opts, err := downloadOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		opts.SessionName = sessionNameFromID(args[0])
+		if err := runDownloadOnly(opts); err != nil {
+			return fmt.Errorf("Error downloading photos: %w", err)
+		}
+		return nil
+	},
+}
+
+// セッション一覧ファイルのパスを取得
+func getSessionsPath() (string, error) {
+	stateDir, err := getStateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(stateDir, "sessions.json"), nil
+}
+
+// 追跡中のセッションを読み込み
+func loadTrackedSessions() ([]trackedSession, error) {
+	sessionsPath, err := getSessionsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(sessionsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sessions file: %w", err)
+	}
+
+	var sessions []trackedSession
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to parse sessions file: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// 追跡中のセッションを保存
+func saveTrackedSessions(sessions []trackedSession) error {
+	sessionsPath, err := getSessionsPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sessions: %w", err)
+	}
+
+	if err := os.WriteFile(sessionsPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write sessions file: %w", err)
+	}
+
+	return nil
+}
+
+// 作成したセッションを追跡対象に追加
+func trackSession(session *PickerSession, command string) error {
+	sessions, err := loadTrackedSessions()
+	if err != nil {
+		return err
+	}
+
+	sessions = append(sessions, trackedSession{
+		ID:         sessionIDFromName(session.Name),
+		PickerUri:  session.PickerUri,
+		Command:    command,
+		CreatedAt:  time.Now(),
+		ExpireTime: session.ExpireTime,
+		Profile:    currentProfileName(),
+	})
+
+	return saveTrackedSessions(sessions)
+}
+
+// セッションを追跡対象から外す
+func untrackSession(sessionName string) error {
+	sessions, err := loadTrackedSessions()
+	if err != nil {
+		return err
+	}
+
+	id := sessionIDFromName(sessionName)
+	remaining := sessions[:0]
+	for _, session := range sessions {
+		if session.ID != id {
+			remaining = append(remaining, session)
+		}
+	}
+
+	return saveTrackedSessions(remaining)
+}
+
+// セッションを作成（または既存のセッションを取得）し、選択されたメディアアイテムを取得する
+// エラー時もセッションを取得できていれば返すので、呼び出し側で削除できる
+// 進捗メッセージは progress に書き出す
+func selectMediaItems(ctx context.Context, pickerClient *PickerClient, sessionName, command string, progress io.Writer) (*PickerSession, []MediaItem, error) {
+	var session *PickerSession
+	var err error
+
+	if sessionName != "" {
+		// 既存のセッションを再利用
+		session, err = pickerClient.GetSession(ctx, sessionName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get picker session: %w", err)
+		}
+		if !session.MediaItemsSet {
+			fmt.Fprintf(progress, "Google Photos Picker を開いてください:\n%s\n\n", session.PickerUri)
+			fmt.Fprintln(progress, "ブラウザで上記URLを開き、写真を選択してください...")
+		}
+	} else {
+		// セッションを作成
+		fmt.Fprintln(progress, "Google Photos Picker セッションを作成中...")
+		session, err = pickerClient.CreateSession(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create picker session: %w", err)
+		}
+		if err := trackSession(session, command); err != nil {
+			fmt.Fprintf(progress, "Warning: failed to track session: %v\n", err)
+		}
+
+		fmt.Fprintf(progress, "Google Photos Picker を開いてください:\n%s\n\n", session.PickerUri)
+		fmt.Fprintln(progress, "ブラウザで上記URLを開き、写真を選択してください...")
+	}
+
+	// 選択完了を待機
+	if err := pickerClient.WaitForSelection(ctx, session, progress); err != nil {
+		return session, nil, fmt.Errorf("failed to wait for selection: %w", err)
+	}
+
+	// 選択された写真を取得
+	fmt.Fprintln(progress, "選択された写真を取得中...")
+	mediaItems, err := pickerClient.ListMediaItems(ctx, session.Name)
+	if err != nil {
+		return session, nil, fmt.Errorf("failed to list selected media items: %w", err)
+	}
+
+	return session, mediaItems, nil
+}
+
+// セッションを削除して追跡対象から外す
+// 呼び出し元のコンテキストが Ctrl-C でキャンセルされていても削除できるよう独自のコンテキストを使う
+func deletePickerSession(pickerClient *PickerClient, sessionName string, progress io.Writer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := pickerClient.DeleteSession(ctx, sessionName); err != nil {
+		fmt.Fprintf(progress, "Warning: failed to delete picker session %s: %v\n", sessionIDFromName(sessionName), err)
+		return
+	}
+	if err := untrackSession(sessionName); err != nil {
+		fmt.Fprintf(progress, "Warning: failed to untrack session: %v\n", err)
+	}
+}
+
+// 設定済みの認証情報で PickerClient を作成
+func newPickerClientFromConfig() (*PickerClient, error) {
+	config, err := getGoogleConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Google config: %w", err)
+	}
+
+	client, err := getClient(context.Background(), config, os.Stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	pickerClient := NewPickerClient(client)
+	pickerClient.SetBaseURL(getPickerAPIBaseURL())
+	return pickerClient, nil
+}
+
+// 使用中のプロファイルで作成されたセッションのみを取得
+func loadProfileSessions() ([]trackedSession, error) {
+	sessions, err := loadTrackedSessions()
+	if err != nil {
+		return nil, err
+	}
+	profile := currentProfileName()
+	return slices.DeleteFunc(sessions, func(s trackedSession) bool {
+		return !s.belongsTo(profile)
+	}), nil
+}
+
+func runSessionsList() error {
+	sessions, err := loadProfileSessions()
+	if err != nil {
+		return err
+	}
+
+	if len(sessions) == 0 {
+		fmt.Println("追跡中のセッションはありません。")
+		return nil
+	}
+
+	fmt.Printf("追跡中のセッション (%d件):\n\n", len(sessions))
+	for _, session := range sessions {
+		status := "有効"
+		if session.expired() {
+			status = "期限切れ"
+		}
+		fmt.Printf("%s [%s]\n", session.ID, status)
+		fmt.Printf("   作成日時: %s (%s)\n", session.CreatedAt.Local().Format("2006-01-02 15:04:05"), session.Command)
+		if session.ExpireTime != "" {
+			fmt.Printf("   有効期限: %s\n", session.ExpireTime)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func runSessionsShow(sessionID string) error {
+	pickerClient, err := newPickerClientFromConfig()
+	if err != nil {
+		return err
+	}
+
+	session, err := pickerClient.GetSession(context.Background(), sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get picker session: %w", err)
+	}
+
+	fmt.Printf("ID: %s\n", sessionIDFromName(session.Name))
+	fmt.Printf("Picker URL: %s\n", session.PickerUri)
+	fmt.Printf("選択済み: %t\n", session.MediaItemsSet)
+	if session.ExpireTime != "" {
+		fmt.Printf("有効期限: %s\n", session.ExpireTime)
+	}
+	if session.PollingConfig.PollInterval != "" {
+		fmt.Printf("ポーリング間隔: %s (タイムアウト: %s)\n", session.PollingConfig.PollInterval, session.PollingConfig.TimeoutIn)
+	}
+
+	return nil
+}
+
+func runSessionsDelete(sessionIDs []string, all bool) error {
+	if all {
+		sessions, err := loadProfileSessions()
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			sessionIDs = append(sessionIDs, session.ID)
+		}
+	}
+
+	if len(sessionIDs) == 0 {
+		fmt.Println("削除するセッションはありません。")
+		return nil
+	}
+
+	pickerClient, err := newPickerClientFromConfig()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, id := range sessionIDs {
+		sessionName := sessionNameFromID(id)
+		err := pickerClient.DeleteSession(context.Background(), sessionName)
+		var apiErr *APIError
+		switch {
+		case err == nil:
+			fmt.Printf("🗑️  削除しました: %s\n", sessionIDFromName(id))
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound, errors.Is(err, ErrTokenRevoked):
+			// 期限切れなどで既に存在しない（または削除できない）セッションは追跡対象から外すだけにする
+			fmt.Printf("⚠️  %s: %v\n", id, err)
+		default:
+			// 一時的なエラーなどは再実行できるよう追跡対象に残す
+			fmt.Printf("❌ %s: %v\n", id, err)
+			failed = append(failed, sessionIDFromName(id))
+			continue
+		}
+		if err := untrackSession(sessionName); err != nil {
+			return err
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to delete %d session(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func init() {
+	sessionsDeleteCmd.Flags().Bool("all", false, "Delete all locally tracked sessions")
+	addDownloadFlags(sessionsResumeCmd)
+
+	sessionsCmd.AddCommand(sessionsListCmd)
+	sessionsCmd.AddCommand(sessionsShowCmd)
+	sessionsCmd.AddCommand(sessionsDeleteCmd)
+	sessionsCmd.AddCommand(sessionsResumeCmd)
+
+	rootCmd.AddCommand(sessionsCmd)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// 設定項目（config get/set のキーと、環境変数・フラグとの対応）
+type configKey struct {
+	// config.yaml のキー（download 配下はドット区切り）
+	Name    string
+	Default string
+	// 環境変数（先頭ほど優先）
+	Envs []string
+	// フラグ名（コマンドのフラグはそのコマンドにある場合のみ使う）
+	Flag string
+	// グローバルフラグの場合はその変数
+	FlagVar *string
+	Secret  bool
+	// 値の検証（空文字は常に許可）
+	Validate func(string) error
+}
+
+var configKeys = []configKey{
+	{Name: "google_client_id", Envs: []string{"GPHOTO_CLIENT_ID", "GOOGLE_CLIENT_ID"}},
+	{Name: "google_client_secret", Envs: []string{"GPHOTO_CLIENT_SECRET", "GOOGLE_CLIENT_SECRET"}, Secret: true},
+	{Name: "google_redirect_uri", Default: "http://localhost:8080/auth/callback", Envs: []string{"GPHOTO_REDIRECT_URI", "GOOGLE_REDIRECT_URI"}},
+	{Name: "google_scope", Default: "https://www.googleapis.com/auth/photospicker.mediaitems.readonly", Envs: []string{"GPHOTO_SCOPE", "GOOGLE_SCOPE"}},
+	{Name: "auth_method", Default: "server", Envs: []string{"GPHOTO_AUTH_METHOD", "AUTH_METHOD"}, Validate: validateAuthMethod},
+	{Name: "credential_store", Default: credentialStorePlaintext, Envs: []string{"GPHOTO_CREDENTIAL_STORE"}, Validate: validateCredentialStore},
+	{Name: "output_format", Default: outputFormatText, Envs: []string{"GPHOTO_OUTPUT_FORMAT"}, Flag: "output-format", Validate: validateOutputFormat},
+	{Name: "renderer", Default: rendererAuto, Envs: []string{"GPHOTO_RENDERER"}, Flag: "renderer", Validate: validateRenderer},
+	{Name: "ascii_color", Default: asciiColorNone, Envs: []string{"GPHOTO_ASCII_COLOR"}, Flag: "ascii-color", Validate: validateASCIIColor},
+	{Name: "cell_aspect", Envs: []string{"GPHOTO_CELL_ASPECT"}, Flag: "cell-aspect", Validate: validatePositiveFloat},
+	{Name: "cache.max_size", Default: "1GiB", Envs: []string{"GPHOTO_CACHE_MAX_SIZE"}, Validate: validateByteSize},
+	{Name: "heif_decoder", Envs: []string{"GPHOTO_HEIF_DECODER"}, Validate: validateHEIFDecoder},
+	{Name: "download.output_dir", Default: "~/gphoto-downloads", Envs: []string{"GPHOTO_DOWNLOAD_DIR"}, Flag: "output"},
+	{Name: "download.concurrency", Default: "4", Envs: []string{"GPHOTO_DOWNLOAD_CONCURRENCY"}, Flag: "concurrency", Validate: validatePositiveInt},
+	{Name: "download.on_conflict", Default: conflictRename, Envs: []string{"GPHOTO_DOWNLOAD_ON_CONFLICT"}, Flag: "on-conflict", Validate: validateConflictPolicy},
+	{Name: "download.name_template", Envs: []string{"GPHOTO_DOWNLOAD_NAME_TEMPLATE"}, Flag: "name-template"},
+	{Name: "download.convert", Envs: []string{"GPHOTO_DOWNLOAD_CONVERT"}, Flag: "convert", Validate: validateConvertFormat},
+	{Name: "download.quality", Default: "90", Envs: []string{"GPHOTO_DOWNLOAD_QUALITY"}, Flag: "quality", Validate: validateQuality},
+	{Name: "picker_api_base_url", Default: defaultPickerAPIBaseURL, Envs: []string{"GPHOTO_PICKER_API_URL"}, Flag: "picker-api-url", FlagVar: &flagPickerAPIBaseURL},
+	{Name: "auth_url", Default: defaultAuthURL, Envs: []string{"GPHOTO_AUTH_URL"}, Flag: "auth-url", FlagVar: &flagAuthURL},
+	{Name: "token_url", Default: defaultTokenURL, Envs: []string{"GPHOTO_TOKEN_URL"}, Flag: "token-url", FlagVar: &flagTokenURL},
+	{Name: "device_auth_url", Default: defaultDeviceAuthURL, Envs: []string{"GPHOTO_DEVICE_AUTH_URL"}, Flag: "device-auth-url", FlagVar: &flagDeviceAuthURL},
+	{Name: "revoke_url", Default: defaultRevokeURL, Envs: []string{"GPHOTO_REVOKE_URL"}, Flag: "revoke-url", FlagVar: &flagRevokeURL},
+	{Name: "tokeninfo_url", Default: defaultTokenInfoURL, Envs: []string{"GPHOTO_TOKENINFO_URL"}, Flag: "tokeninfo-url", FlagVar: &flagTokenInfoURL},
+}
+
+// 設定項目ごとの値の出どころ（default / file / env / flag）
+type configSources map[string]string
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the effective value of a setting",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reveal, _ := cmd.Flags().GetBool("reveal")
+		if err := runConfigGet(os.Stdout, args[0], reveal); err != nil {
+			return fmt.Errorf("Error getting config: %w", err)
+		}
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a value in config.yaml for the current profile (empty value removes it)",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runConfigSet(args[0], args[1]); err != nil {
+			return fmt.Errorf("Error setting config: %w", err)
+		}
+		return nil
+	},
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Open config.yaml in $VISUAL or $EDITOR",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runConfigEdit(); err != nil {
+			return fmt.Errorf("Error editing config: %w", err)
+		}
+		return nil
+	},
+}
+
+func findConfigKey(name string) (configKey, error) {
+	for _, key := range configKeys {
+		if key.Name == name {
+			return key, nil
+		}
+	}
+	names := make([]string, 0, len(configKeys))
+	for _, key := range configKeys {
+		names = append(names, key.Name)
+	}
+	return configKey{}, fmt.Errorf("unknown config key %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// yaml タグ（ドット区切り）から Config のフィールドを取得
+func configField(config *Config, name string) reflect.Value {
+	v := reflect.ValueOf(config).Elem()
+	for _, part := range strings.Split(name, ".") {
+		field := reflect.Value{}
+		for i := 0; i < v.NumField(); i++ {
+			if tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ","); tag == part {
+				field = v.Field(i)
+				break
+			}
+		}
+		if !field.IsValid() {
+			panic("config key without a Config field: " + name)
+		}
+		v = field
+	}
+	return v
+}
+
+func getConfigValue(config *Config, name string) string {
+	field := configField(config, name)
+	switch field.Kind() {
+	case reflect.Int:
+		if field.Int() == 0 {
+			return ""
+		}
+		return strconv.FormatInt(field.Int(), 10)
+	case reflect.Float64:
+		if field.Float() == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(field.Float(), 'g', -1, 64)
+	}
+	return field.String()
+}
+
+func setConfigValue(config *Config, key configKey, value string) error {
+	if value != "" && key.Validate != nil {
+		if err := key.Validate(value); err != nil {
+			return err
+		}
+	}
+
+	field := configField(config, key.Name)
+	switch field.Kind() {
+	case reflect.Int:
+		n := 0
+		if value != "" {
+			var err error
+			if n, err = strconv.Atoi(value); err != nil {
+				return fmt.Errorf("%s must be a number: %q", key.Name, value)
+			}
+		}
+		field.SetInt(int64(n))
+		return nil
+	case reflect.Float64:
+		f := 0.0
+		if value != "" {
+			var err error
+			if f, err = strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("%s must be a number: %q", key.Name, value)
+			}
+		}
+		field.SetFloat(f)
+		return nil
+	}
+	field.SetString(value)
+	return nil
+}
+
+// oob は以前の設定ファイルを読み込めるよう、device の別名として受け付ける（非推奨）
+func validateAuthMethod(method string) error {
+	switch method {
+	case "server", "device", "oob":
+		return nil
+	}
+	return fmt.Errorf("invalid auth method %q (want server or device; oob is a deprecated alias for device)", method)
+}
+
+func validatePositiveInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+		return fmt.Errorf("must be a positive number: %q", value)
+	}
+	return nil
+}
+
+func validatePositiveFloat(value string) error {
+	if f, err := strconv.ParseFloat(value, 64); err != nil || f <= 0 {
+		return fmt.Errorf("must be a positive number: %q", value)
+	}
+	return nil
+}
+
+// すべての項目をデフォルト値にした設定
+func configDefaults() *Config {
+	config := &Config{}
+	for _, key := range configKeys {
+		setConfigValue(config, key, key.Default)
+	}
+	return config
+}
+
+// 設定を デフォルト < 設定ファイル < 環境変数 < フラグ の優先順で重ねて決定する
+// cmd を渡した場合は、そのコマンドで明示的に指定されたフラグも反映する
+func loadLayeredConfig(cmd *cobra.Command) (*Config, configSources, error) {
+	cf, err := loadConfigFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	profile := activeProfileName(cf)
+	fileConfig := cf.Profiles[profile]
+
+	config := configDefaults()
+	sources := configSources{}
+	for _, key := range configKeys {
+		sources[key.Name] = "default"
+
+		if fileConfig != nil {
+			if value := getConfigValue(fileConfig, key.Name); value != "" {
+				if err := setConfigValue(config, key, value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s in config file: %w", key.Name, err)
+				}
+				sources[key.Name] = "file (profile: " + profile + ")"
+			}
+		}
+
+		for _, envName := range key.Envs {
+			if value := os.Getenv(envName); value != "" {
+				if err := setConfigValue(config, key, value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", envName, err)
+				}
+				sources[key.Name] = "env " + envName
+				break
+			}
+		}
+
+		value := ""
+		if key.FlagVar != nil {
+			value = *key.FlagVar
+		} else if cmd != nil && key.Flag != "" && cmd.Flags().Changed(key.Flag) {
+			value = cmd.Flags().Lookup(key.Flag).Value.String()
+		}
+		if value != "" {
+			if err := setConfigValue(config, key, value); err != nil {
+				return nil, nil, fmt.Errorf("invalid --%s: %w", key.Flag, err)
+			}
+			sources[key.Name] = "flag --" + key.Flag
+		}
+	}
+
+	return config, sources, nil
+}
+
+// シークレットは config show と同様にマスクし、reveal の場合のみそのまま表示する
+func runConfigGet(w io.Writer, name string, reveal bool) error {
+	key, err := findConfigKey(name)
+	if err != nil {
+		return err
+	}
+
+	config, _, err := loadLayeredConfig(nil)
+	if err != nil {
+		return err
+	}
+	if key.Secret {
+		if err := resolveClientSecret(config); err != nil {
+			return err
+		}
+	}
+
+	value := getConfigValue(config, key.Name)
+	if key.Secret && !reveal {
+		value = maskString(value)
+	}
+	fmt.Fprintln(w, value)
+	return nil
+}
+
+func runConfigSet(name, value string) error {
+	key, err := findConfigKey(name)
+	if err != nil {
+		return err
+	}
+	if key.Name == "credential_store" {
+		return errors.New("use 'config migrate-credentials --to <store>' to change the credential store")
+	}
+
+	// デフォルト値まで設定ファイルに書き込まないよう、ファイルにある値だけを読み込む
+	config, err := loadProfileConfig()
+	if err != nil {
+		return err
+	}
+	if err := setConfigValue(config, key, value); err != nil {
+		return err
+	}
+	// 平文ファイル以外ではシークレットは認証情報ストアにあるため、そちらからも削除する
+	if key.Name == "google_client_secret" && value == "" {
+		store, err := activeCredentialStore()
+		if err != nil {
+			return err
+		}
+		if err := store.Delete(credentialKeyClientSecret); err != nil {
+			return fmt.Errorf("failed to remove client secret from %s: %w", store.Name(), err)
+		}
+	}
+	// クライアントシークレットは認証情報ストアに保存する
+	if err := saveConfigWithCredentials(config); err != nil {
+		return err
+	}
+
+	if value == "" {
+		fmt.Printf("✅ %s を削除しました\n", key.Name)
+	} else if key.Secret {
+		fmt.Printf("✅ %s = %s\n", key.Name, maskString(value))
+	} else {
+		fmt.Printf("✅ %s = %s\n", key.Name, value)
+	}
+	if key.Name == "auth_method" && value == "oob" {
+		fmt.Println("⚠️  oob は Google により廃止されたため、デバイス認証方式（device）として扱われます。device を指定してください")
+	}
+	for _, envName := range key.Envs {
+		if os.Getenv(envName) != "" {
+			fmt.Printf("⚠️  環境変数 %s が設定されているため、そちらが優先されます\n", envName)
+			break
+		}
+	}
+	return nil
+}
+
+func runConfigEdit() error {
+	// 設定ファイルがない場合は空のプロファイルで作成しておく
+	configPath, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		if err := saveConfig(getDefaultConfig()); err != nil {
+			return err
+		}
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+
+	// EDITOR="code --wait" のように引数を含む場合がある
+	args := append(strings.Fields(editor), configPath)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run editor %q: %w", editor, err)
+	}
+
+	// 保存された内容を検証
+	if _, _, err := loadLayeredConfig(nil); err != nil {
+		return fmt.Errorf("config file has errors, run 'config edit' again to fix it: %w", err)
+	}
+	fmt.Printf("✅ %s を更新しました\n", configPath)
+	return nil
+}
+
+// config show --source: 各設定の値と出どころを表示
+func printConfigSources(config *Config, sources configSources) {
+	names := make([]string, 0, len(configKeys))
+	width := 0
+	for _, key := range configKeys {
+		names = append(names, key.Name)
+		width = max(width, len(key.Name))
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		key, _ := findConfigKey(name)
+		value := getConfigValue(config, name)
+		if key.Secret {
+			value = maskString(value)
+		}
+		if value == "" {
+			value = "(なし)"
+		}
+		fmt.Printf("%-*s  %s  [%s]\n", width, name, value, sources[name])
+	}
+}
+
+func init() {
+	configGetCmd.Flags().Bool("reveal", false, "Print secret values (google_client_secret) without masking")
+
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configEditCmd)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestLayeredConfigPrecedence(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+	t.Setenv("GPHOTO_PROFILE", "")
+
+	if err := runConfigSet("download.concurrency", "2"); err != nil {
+		t.Fatalf("config set concurrency: %v", err)
+	}
+	if err := runConfigSet("download.on_conflict", conflictSkip); err != nil {
+		t.Fatalf("config set on_conflict: %v", err)
+	}
+	if err := runConfigSet("output_format", outputFormatJSON); err != nil {
+		t.Fatalf("config set output_format: %v", err)
+	}
+	t.Setenv("GPHOTO_DOWNLOAD_CONCURRENCY", "3")
+	t.Setenv("GPHOTO_OUTPUT_FORMAT", outputFormatCSV)
+
+	cmd := &cobra.Command{}
+	addDownloadFlags(cmd)
+	cmd.Flags().String("output-format", outputFormatText, "")
+	cmd.Flags().Set("output-format", outputFormatYAML)
+
+	config, sources, err := loadLayeredConfig(cmd)
+	if err != nil {
+		t.Fatalf("loadLayeredConfig: %v", err)
+	}
+
+	tests := []struct {
+		key        string
+		value      string
+		sourcePart string
+	}{
+		{key: "download.output_dir", value: "~/gphoto-downloads", sourcePart: "default"},
+		{key: "download.on_conflict", value: conflictSkip, sourcePart: "file"},
+		{key: "download.concurrency", value: "3", sourcePart: "env GPHOTO_DOWNLOAD_CONCURRENCY"},
+		{key: "output_format", value: outputFormatYAML, sourcePart: "flag --output-format"},
+		{key: "picker_api_base_url", value: fake.URL + "/v1", sourcePart: "file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := getConfigValue(config, tt.key); got != tt.value {
+				t.Errorf("value = %q, want %q", got, tt.value)
+			}
+			if !strings.HasPrefix(sources[tt.key], tt.sourcePart) {
+				t.Errorf("source = %q, want %q", sources[tt.key], tt.sourcePart)
+			}
+		})
+	}
+
+	// 設定ファイルには環境変数・フラグの値は書き込まれない
+	fileConfig, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileConfig.Download.Concurrency != 2 || fileConfig.OutputFormat != outputFormatJSON {
+		t.Errorf("file config = %+v", fileConfig.Download)
+	}
+}
+
+func TestConfigSetDoesNotWriteDefaults(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+	t.Setenv("GPHOTO_PROFILE", "work")
+
+	if err := runConfigSet("download.concurrency", "2"); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+
+	// 変更したキー以外（デフォルト値）はファイルに書き込まれない
+	config, err := loadProfileConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{}
+	want.Download.Concurrency = 2
+	if *config != want {
+		t.Errorf("profile config = %+v, want only download.concurrency", *config)
+	}
+}
+
+func TestConfigSetValidates(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{key: "no_such_key", value: "x"},
+		{key: "download.concurrency", value: "many"},
+		{key: "download.concurrency", value: "0"},
+		{key: "download.on_conflict", value: "merge"},
+		{key: "auth_method", value: "carrier-pigeon"},
+		{key: "cell_aspect", value: "tall"},
+		{key: "cell_aspect", value: "-2"},
+		{key: "ascii_color", value: "16"},
+		{key: "credential_store", value: credentialStoreKeyring},
+	}
+	for _, tt := range tests {
+		if err := runConfigSet(tt.key, tt.value); err == nil {
+			t.Errorf("config set %s %q should fail", tt.key, tt.value)
+		}
+	}
+
+	t.Setenv("GPHOTO_DOWNLOAD_CONCURRENCY", "-1")
+	if _, _, err := loadLayeredConfig(nil); err == nil || !strings.Contains(err.Error(), "GPHOTO_DOWNLOAD_CONCURRENCY") {
+		t.Errorf("invalid env value: err = %v", err)
+	}
+}
+
+func TestConfigGetMasksSecret(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+
+	var out bytes.Buffer
+	if err := runConfigGet(&out, "google_client_secret", false); err != nil {
+		t.Fatalf("config get: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != maskString("test-client-secret") {
+		t.Errorf("config get google_client_secret = %q, want it masked", got)
+	}
+
+	out.Reset()
+	if err := runConfigGet(&out, "google_client_secret", true); err != nil {
+		t.Fatalf("config get --reveal: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "test-client-secret" {
+		t.Errorf("config get --reveal google_client_secret = %q", got)
+	}
+
+	// シークレット以外はそのまま表示する
+	out.Reset()
+	if err := runConfigGet(&out, "google_client_id", false); err != nil {
+		t.Fatalf("config get: %v", err)
+	}
+	if strings.Contains(out.String(), "*") {
+		t.Errorf("config get google_client_id should not be masked: %q", out.String())
+	}
+}
+
+func TestLegacyEnvOverridesWithoutDotEnv(t *testing.T) {
+	fake := newFakePickerServer(t, 0)
+	setupFakeEnv(t, fake, nil)
+	t.Setenv("GOOGLE_CLIENT_ID", "env-client-id")
+
+	oauthConfig, err := getGoogleConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oauthConfig.ClientID != "env-client-id" {
+		t.Errorf("client id = %q, want env-client-id", oauthConfig.ClientID)
+	}
+}
+
+func TestValidateAuthMethod(t *testing.T) {
+	for _, method := range []string{"server", "device", "oob"} {
+		if err := validateAuthMethod(method); err != nil {
+			t.Errorf("validateAuthMethod(%q): %v", method, err)
+		}
+	}
+	// 以前の設定を読み込めるよう oob は受け付けるが、非推奨であることをエラーで案内する
+	if err := validateAuthMethod("browser"); err == nil || !strings.Contains(err.Error(), "oob is a deprecated alias for device") {
+		t.Errorf("validateAuthMethod(browser) = %v", err)
+	}
+}
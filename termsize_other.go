@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// ピクセル数を取得できない環境では文字数のみ
+func terminalSize(f *os.File) (terminalDimensions, error) {
+	cols, rows, err := term.GetSize(int(f.Fd()))
+	if err != nil {
+		return terminalDimensions{}, err
+	}
+	return terminalDimensions{Cols: cols, Rows: rows}, nil
+}
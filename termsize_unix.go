@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctl(TIOCGWINSZ) でターミナルの文字数とピクセル数を取得
+func terminalSize(f *os.File) (terminalDimensions, error) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return terminalDimensions{}, err
+	}
+	return terminalDimensions{
+		Cols:        int(ws.Col),
+		Rows:        int(ws.Row),
+		PixelWidth:  int(ws.Xpixel),
+		PixelHeight: int(ws.Ypixel),
+	}, nil
+}
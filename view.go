@@ -1,34 +1,397 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
+// プレビュー用に取得するサムネイルの最大サイズ
+const previewImageSize = 512
+
+// プレビュー以外（番号・メタデータ・キー操作の案内）に使う行数
+const previewReservedRows = 13
+
 var viewCmd = &cobra.Command{
 	Use:   "view",
-	Short: "Quick view mode - select and immediately view photos",
-	Run: func(cmd *cobra.Command, args []string) {
-		if err := runQuickView(); err != nil {
-			log.Fatalf("Error in view mode: %v", err)
+	Short: "Quick view mode - select photos and preview them in the terminal",
+	Long: `Select photos with Google Photos Picker and preview them one by one in the terminal.
+
+Keys: n/→/space/Enter next, p/← previous, o open in the default viewer, d download, q quit.
+When stdout is not a terminal, only the metadata is printed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// download キーの保存先などは download コマンドと同じ設定を使う
+		config, _, err := loadLayeredConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		width, _ := cmd.Flags().GetInt("width")
+		keepSession, _ := cmd.Flags().GetBool("keep-session")
+		useCache, _ := cmd.Flags().GetBool("cache")
+		opts := viewOptions{
+			Width:        width,
+			KeepSession:  keepSession,
+			UseCache:     useCache,
+			Renderer:     config.Renderer,
+			ASCIIColor:   config.ASCIIColor,
+			CellAspect:   config.CellAspect,
+			OutputDir:    expandHome(config.Download.OutputDir),
+			OnConflict:   config.Download.OnConflict,
+			NameTemplate: config.Download.NameTemplate,
+			Convert:      config.Download.Convert,
+			Quality:      config.Download.Quality,
+			HEIFDecoder:  config.HEIFDecoder,
+		}
+		if err := runQuickView(opts); err != nil {
+			return fmt.Errorf("Error in view mode: %w", err)
 		}
+		return nil
 	},
 }
 
-func runQuickView() error {
+// view コマンドのオプション
+type viewOptions struct {
+	// プレビューの幅（文字数、0 の場合はターミナルの幅）
+	Width int
+	// プレビューの描画方式（auto の場合はターミナルから判定）
+	Renderer   string
+	ASCIIColor string
+	// 1文字の縦横比（0 の場合はターミナルから取得）
+	CellAspect  float64
+	KeepSession bool
+	// download キーで保存する場合の出力先
+	OutputDir    string
+	OnConflict   string
+	NameTemplate string
+	Convert      string
+	Quality      int
+	// download キーでもキャッシュから復元し、保存したファイルをキャッシュにコピーする（download --cache と同じ）
+	UseCache bool
+	// HEIF のプレビュー・変換に使う外部コマンド（空の場合は自動検出）
+	HEIFDecoder string
+}
+
+// プレビュー中のキー操作
+type viewAction int
+
+const (
+	viewNone viewAction = iota
+	viewNext
+	viewPrev
+	viewOpen
+	viewDownload
+	viewQuit
+)
+
+func runQuickView(opts viewOptions) error {
 	// 設定確認
 	if !isConfigured() {
-		fmt.Println("❌ Google OAuth credentials are not configured.")
-		fmt.Println("Please run setup first: ./gphoto-cli setup")
-		return fmt.Errorf("not configured")
+		return ErrNotConfigured
+	}
+	setHEIFDecoderCommand(opts.HEIFDecoder)
+
+	config, err := getGoogleConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get Google config: %w", err)
+	}
+
+	// トークンは実行中に期限が切れても自動でリフレッシュされる
+	client, err := getClient(context.Background(), config, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	pickerClient := NewPickerClient(client)
+	pickerClient.SetBaseURL(getPickerAPIBaseURL())
+
+	// Ctrl-C でも後片付け（セッション削除）が行われるようにする
+	ctx, stop := notifyInterruptContext()
+	defer stop()
+
+	fmt.Println("🖼️  Quick View Mode - Select photos and preview them")
+	session, mediaItems, err := selectMediaItems(ctx, pickerClient, "", "view", os.Stdout)
+	if session != nil {
+		if opts.KeepSession {
+			fmt.Printf("ℹ️  セッションを保持しています: %s\n", sessionIDFromName(session.Name))
+		} else {
+			defer deletePickerSession(pickerClient, session.Name, os.Stdout)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// 端末でない場合（パイプやリダイレクト）はメタデータのみ表示
+	if len(mediaItems) == 0 || !term.IsTerminal(int(os.Stdout.Fd())) || !term.IsTerminal(int(os.Stdin.Fd())) {
+		writeMediaItemsText(os.Stdout, mediaItems)
+		return nil
 	}
 
-	fmt.Println("🖼️  Quick View Mode - Select photos and view metadata")
-	return runPicker() // 画像表示機能を削除し、基本的なpicker機能のみ使用
+	qv, err := newQuickViewer(client, mediaItems, opts)
+	if err != nil {
+		return err
+	}
+	qv.clearScreen = true
+
+	keys := bufio.NewReader(os.Stdin)
+	return qv.run(ctx, func() (viewAction, error) {
+		return readViewKeyFromTerminal(os.Stdin, keys)
+	})
+}
+
+// 選択した写真を1件ずつプレビューする
+type quickViewer struct {
+	client   *http.Client
+	viewer   *ImageViewer
+	renderer imageRenderer
+	items    []MediaItem
+	opts     viewOptions
+	planner  *downloadPlanner
+	// 保存済みのファイル（メディアID → パス）
+	downloaded  map[string]string
+	clearScreen bool
+}
+
+func newQuickViewer(client *http.Client, items []MediaItem, opts viewOptions) (*quickViewer, error) {
+	renderer, err := newImageRenderer(opts.Renderer, opts.ASCIIColor)
+	if err != nil {
+		return nil, err
+	}
+
+	viewer, err := NewImageViewer(client)
+	if err != nil {
+		return nil, err
+	}
+
+	planner, err := newDownloadPlanner(opts.OutputDir, opts.NameTemplate, opts.OnConflict, false, opts.Convert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quickViewer{
+		client:     client,
+		viewer:     viewer,
+		renderer:   renderer,
+		items:      items,
+		opts:       opts,
+		planner:    planner,
+		downloaded: map[string]string{},
+	}, nil
+}
+
+func (qv *quickViewer) run(ctx context.Context, readKey func() (viewAction, error)) error {
+	index := 0
+	redraw := true
+	for {
+		if redraw {
+			qv.show(ctx, index)
+			redraw = false
+			// プレビューの取得中に中断された場合
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+
+		fmt.Print("[n]次へ [p]前へ [o]開く [d]ダウンロード [q]終了 > ")
+		action, err := readKey()
+		fmt.Println()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read key: %w", err)
+		}
+
+		item := qv.items[index]
+		switch action {
+		case viewNext:
+			if index+1 < len(qv.items) {
+				index++
+				redraw = true
+			} else {
+				fmt.Println("   最後の写真です")
+			}
+		case viewPrev:
+			if index > 0 {
+				index--
+				redraw = true
+			} else {
+				fmt.Println("   最初の写真です")
+			}
+		case viewOpen:
+			if err := qv.open(ctx, item); err != nil {
+				fmt.Printf("   ❌ Error: %v\n", err)
+			}
+		case viewDownload:
+			if err := qv.download(ctx, item); err != nil {
+				fmt.Printf("   ❌ Error: %v\n", err)
+			}
+		case viewQuit:
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// メタデータとプレビューを表示
+func (qv *quickViewer) show(ctx context.Context, index int) {
+	if qv.clearScreen {
+		fmt.Print("\033[H\033[2J")
+	}
+
+	item := qv.items[index]
+	fmt.Printf("[%d/%d]\n", index+1, len(qv.items))
+	writeMediaItemText(os.Stdout, index, item)
+	fmt.Println()
+
+	// 動画もサイズ指定の URL ではサムネイル（静止画）が返る
+	// 取得済みのプレビューはキャッシュから読み込む
+	path, err := qv.viewer.DownloadImage(ctx, item, getImageThumbnailURL(item.MediaFile.BaseUrl, previewImageSize, previewImageSize))
+	if err != nil {
+		fmt.Printf("   ⚠️  プレビューを取得できませんでした: %v\n", err)
+		return
+	}
+	// 表示のたびに取得してウィンドウサイズの変更に追従する
+	dims, _ := terminalSize(os.Stdout)
+	qv.viewer.Display(path, previewBox(dims, qv.opts), qv.renderer)
+}
+
+// ターミナルの大きさからプレビューの描画領域を決める
+// 取得できない場合（端末でない場合など）は横80文字・高さ制限なし
+func previewBox(dims terminalDimensions, opts viewOptions) renderBox {
+	box := renderBox{Cols: opts.Width, CellAspect: opts.CellAspect}
+	if box.Cols <= 0 {
+		box.Cols = dims.Cols
+	}
+	if box.Cols <= 0 {
+		box.Cols = 80
+	}
+	if dims.Rows > 0 {
+		box.Rows = max(dims.Rows-previewReservedRows, 5)
+	}
+	if box.CellAspect <= 0 {
+		box.CellAspect = dims.cellAspect()
+	}
+	if dims.Cols > 0 && dims.PixelWidth > 0 {
+		box.CellWidth = dims.PixelWidth / dims.Cols
+	}
+	return box
+}
+
+// 元のサイズで取得して OS の既定のビューアーで開く
+func (qv *quickViewer) open(ctx context.Context, item MediaItem) error {
+	if err := checkVideoProcessingStatus(item); err != nil {
+		return err
+	}
+
+	fmt.Println("   ⏳ 取得中...")
+	path, err := qv.viewer.DownloadImage(ctx, item, getMediaDownloadURL(item, false))
+	if err != nil {
+		return err
+	}
+	return qv.viewer.OpenWithDefaultViewer(path)
+}
+
+// download コマンドと同じ命名規則で出力ディレクトリに保存
+func (qv *quickViewer) download(ctx context.Context, item MediaItem) error {
+	if path, ok := qv.downloaded[item.ID]; ok {
+		fmt.Printf("   ℹ️  保存済みです: %s\n", path)
+		return nil
+	}
+
+	relPath, skip, err := qv.planner.plan(item)
+	if err != nil {
+		return fmt.Errorf("failed to determine output path: %w", err)
+	}
+	outputPath := filepath.Join(qv.opts.OutputDir, relPath)
+	if skip {
+		fmt.Printf("   ⏭️  既に存在するためスキップ: %s\n", outputPath)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	job := downloadJob{Item: item, URL: getMediaDownloadURL(item, false), OutputPath: outputPath, Convert: qv.opts.Convert, Quality: qv.opts.Quality}
+	if qv.opts.UseCache {
+		job.Cache = qv.viewer.cache
+	}
+	if err := job.run(ctx, qv.client); err != nil {
+		return err
+	}
+
+	qv.downloaded[item.ID] = outputPath
+	fmt.Printf("   ✅ ダウンロード完了: %s\n", outputPath)
+	return nil
+}
+
+// 端末を raw モードにして1キーずつ読み取る（Enter を押す必要がない）
+func readViewKeyFromTerminal(in *os.File, keys *bufio.Reader) (viewAction, error) {
+	fd := int(in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return viewNone, err
+	}
+	defer term.Restore(fd, state)
+
+	return parseViewKey(keys)
+}
+
+func parseViewKey(keys *bufio.Reader) (viewAction, error) {
+	b, err := keys.ReadByte()
+	if err != nil {
+		return viewNone, err
+	}
+
+	switch b {
+	case 'n', 'j', 'l', ' ', '\r', '\n':
+		return viewNext, nil
+	case 'p', 'k', 'h':
+		return viewPrev, nil
+	case 'o':
+		return viewOpen, nil
+	case 'd':
+		return viewDownload, nil
+	// raw モードでは Ctrl-C / Ctrl-D もキー入力として届く
+	case 'q', 3, 4:
+		return viewQuit, nil
+	case 0x1b:
+		// 矢印キー（ESC [ C など）。ESC 単体は終了
+		if keys.Buffered() == 0 {
+			return viewQuit, nil
+		}
+		if next, _ := keys.ReadByte(); next != '[' {
+			return viewNone, nil
+		}
+		switch code, _ := keys.ReadByte(); code {
+		case 'C', 'B':
+			return viewNext, nil
+		case 'D', 'A':
+			return viewPrev, nil
+		}
+	}
+	return viewNone, nil
 }
 
 func init() {
+	viewCmd.Flags().Int("width", 0, "Preview width in characters (default: terminal width)")
+	viewCmd.Flags().String("renderer", rendererAuto, "Preview renderer: auto, kitty, iterm2, sixel, truecolor or ascii")
+	viewCmd.Flags().String("ascii-color", asciiColorNone, "Colors for the ascii renderer: none, 256 or truecolor")
+	viewCmd.Flags().Float64("cell-aspect", 0, "Character cell height/width ratio (default: from the terminal, or 2)")
+	viewCmd.Flags().Bool("keep-session", false, "Keep the picker session so its selection can be downloaded later with 'sessions resume'")
+	viewCmd.Flags().Bool("cache", false, "Reuse the local cache for the d key and also copy saved files into it (uses extra disk space up to cache.max_size)")
+
 	rootCmd.AddCommand(viewCmd)
-}
\ No newline at end of file
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunQuickViewWithoutTerminal(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	setupFakeEnv(t, fake, nil)
+
+	// テストの標準出力は端末ではないのでメタデータのみ表示される
+	if err := runQuickView(viewOptions{}); err != nil {
+		t.Fatalf("runQuickView: %v", err)
+	}
+	if fake.listedItems != 2 {
+		t.Errorf("listed %d items, want 2", fake.listedItems)
+	}
+	if len(fake.deletedSessions) != 1 {
+		t.Errorf("deleted %d sessions, want 1", len(fake.deletedSessions))
+	}
+}
+
+func TestQuickViewerKeys(t *testing.T) {
+	fake := newFakePickerServer(t, 2)
+	outputDir := setupFakeEnv(t, fake, nil)
+
+	// 1件目は実際の画像にしてプレビューを描画させる
+	img := image.NewGray(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x * 6)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	fake.content["item-0"] = buf.Bytes()
+
+	client := fake.authClient(fake.accessToken)
+	qv, err := newQuickViewer(client, fake.items, viewOptions{Width: 40, Renderer: rendererASCII, OutputDir: outputDir, OnConflict: conflictRename})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 次へ（矢印キー）→ ダウンロード → 同じ写真をもう一度ダウンロード → 前へ → 終了
+	keys := bufio.NewReader(strings.NewReader("\x1b[Cddpq"))
+	if err := qv.run(context.Background(), func() (viewAction, error) { return parseViewKey(keys) }); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(outputDir, "IMG_0001.JPG"))
+	if err != nil {
+		t.Fatalf("downloaded file: %v", err)
+	}
+	if !bytes.Equal(got, fake.content["item-1"]) {
+		t.Error("downloaded content mismatch")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "IMG_0001_1.JPG")); !os.IsNotExist(err) {
+		t.Error("the same item should not be downloaded twice")
+	}
+	// --cache を指定しない場合、保存したファイルはキャッシュにコピーしない
+	if _, ok := qv.viewer.cache.lookup("item-1", "d"); ok {
+		t.Error("the d key should not fill the cache without UseCache")
+	}
+	// 前の写真に戻ったときはキャッシュのプレビューを使う
+	preview := fmt.Sprintf("item-0=w%d-h%d", previewImageSize, previewImageSize)
+	if got := fake.mediaRequests[preview]; got != 1 {
+		t.Errorf("fetched the first preview %d times, want 1", got)
+	}
+}
+
+func TestParseViewKey(t *testing.T) {
+	tests := []struct {
+		input string
+		want  viewAction
+	}{
+		{input: "n", want: viewNext},
+		{input: " ", want: viewNext},
+		{input: "\x1b[C", want: viewNext},
+		{input: "p", want: viewPrev},
+		{input: "\x1b[D", want: viewPrev},
+		{input: "o", want: viewOpen},
+		{input: "d", want: viewDownload},
+		{input: "q", want: viewQuit},
+		{input: "\x03", want: viewQuit},
+		{input: "\x1b", want: viewQuit},
+		{input: "x", want: viewNone},
+	}
+
+	for _, tt := range tests {
+		got, err := parseViewKey(bufio.NewReader(strings.NewReader(tt.input)))
+		if err != nil {
+			t.Fatalf("parseViewKey(%q): %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Errorf("parseViewKey(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewBox(t *testing.T) {
+	tests := []struct {
+		name string
+		dims terminalDimensions
+		opts viewOptions
+		want renderBox
+	}{
+		{name: "not a terminal", want: renderBox{Cols: 80}},
+		{name: "terminal size", dims: terminalDimensions{Cols: 120, Rows: 40}, want: renderBox{Cols: 120, Rows: 27}},
+		{name: "small terminal", dims: terminalDimensions{Cols: 60, Rows: 10}, want: renderBox{Cols: 60, Rows: 5}},
+		{name: "pixel size", dims: terminalDimensions{Cols: 100, Rows: 50, PixelWidth: 800, PixelHeight: 900}, want: renderBox{Cols: 100, Rows: 37, CellAspect: 2.25, CellWidth: 8}},
+		{name: "flags win", dims: terminalDimensions{Cols: 100, Rows: 50, PixelWidth: 800, PixelHeight: 900}, opts: viewOptions{Width: 40, CellAspect: 1.5}, want: renderBox{Cols: 40, Rows: 37, CellAspect: 1.5, CellWidth: 8}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := previewBox(tt.dims, tt.opts); got != tt.want {
+				t.Errorf("previewBox = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}